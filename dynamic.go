@@ -0,0 +1,89 @@
+package pvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DynamicSecret is a multi-field secret issued with a lease, such as database credentials from Vault's database secrets
+// engine. The lease is renewed in the background until it can no longer be renewed, the secret is revoked, or the
+// SecretsClient is closed.
+type DynamicSecret struct {
+	Data          map[string]string // secret fields (eg, "username" and "password"); non-string values are JSON-encoded
+	LeaseID       string
+	LeaseDuration time.Duration // lease duration when the secret was issued
+	Renewable     bool
+
+	expiring     chan struct{}
+	expiringOnce sync.Once
+	revoke       func() error
+}
+
+// Expiring returns a channel that is closed shortly before the lease expires, once it can no longer be renewed (it is not
+// renewable, has reached its maximum TTL, or renewal failed). Callers should then get new credentials.
+func (ds *DynamicSecret) Expiring() <-chan struct{} {
+	return ds.expiring
+}
+
+// Revoke stops renewing the lease and revokes it immediately
+func (ds *DynamicSecret) Revoke() error {
+	if ds.revoke == nil {
+		return nil
+	}
+	return ds.revoke()
+}
+
+// notifyExpiring closes the Expiring channel if it isn't already closed
+func (ds *DynamicSecret) notifyExpiring() {
+	ds.expiringOnce.Do(func() { close(ds.expiring) })
+}
+
+// dynamicSecretGetter is implemented by backends that issue leased secrets
+type dynamicSecretGetter interface {
+	GetDynamic(id string) (*DynamicSecret, error)
+}
+
+// GetDynamic returns a leased secret from the configured backend, which must support dynamic secrets (currently only
+// Vault). The ID is mapped as for Get. Transformers and the maximum secret size do not apply. Outstanding leases are
+// revoked by Close.
+func (sc *SecretsClient) GetDynamic(id string) (*DynamicSecret, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	dg, ok := be.(dynamicSecretGetter)
+	if !ok {
+		return nil, fmt.Errorf("backend does not support dynamic secrets")
+	}
+	return dg.GetDynamic(id)
+}
+
+// Close releases any resources held by the client, including revoking the leases of all dynamic secrets that have not
+// already been revoked and stopping the renewal of PKI certificates. The client should not be used afterwards.
+func (sc *SecretsClient) Close() error {
+	sc.backendMu.Lock()
+	be := sc.backend
+	sc.backendMu.Unlock()
+	if c, ok := be.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// stringValue returns v as a string, JSON-encoding any value that is not a string
+func stringValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -12,7 +12,7 @@ const (
 )
 
 type envVarBackendGetter struct {
-	mapper SecretMapper
+	mapper *secretMapper
 	config *envVarBackend
 }
 
@@ -20,7 +20,7 @@ func newEnvVarBackendGetter(eb *envVarBackend) (*envVarBackendGetter, error) {
 	if eb.mapping == "" {
 		eb.mapping = DefaultEnvVarMapping
 	}
-	sm, err := newSecretMapper(eb.mapping)
+	sm, err := newSecretMapper(eb.mapping, eb.mappingVars)
 	if err != nil {
 		return nil, fmt.Errorf("error with mapping: %v", err)
 	}
@@ -51,10 +51,32 @@ func (ebg *envVarBackendGetter) Get(id string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error mapping id to var name: %v", err)
 	}
+	// IDs are interpolated literally; the resulting name (including any literal text in the mapping) is sanitized
 	vname = ebg.sanitizeName(vname)
 	val, exists := os.LookupEnv(vname)
 	if !exists {
-		return nil, fmt.Errorf("secret not found: %v", vname)
+		return nil, fmt.Errorf("secret not found: %v (id: %v)", vname, id)
 	}
 	return []byte(val), nil
 }
+
+// List returns the IDs of all environment variables matching the mapping. Since variable names are sanitized,
+// the returned IDs are in sanitized form (uppercase with illegal characters replaced by underscores).
+func (ebg *envVarBackendGetter) List(prefix string) ([]string, error) {
+	if _, err := ebg.mapper.listPrefix(prefix); err != nil {
+		return nil, err
+	}
+	vprefix := ebg.sanitizeName(ebg.mapper.prefix)
+	vsuffix := ebg.sanitizeName(ebg.mapper.suffix)
+	ids := []string{}
+	for _, kv := range os.Environ() {
+		vname := strings.SplitN(kv, "=", 2)[0]
+		if len(vname) <= len(vprefix)+len(vsuffix) {
+			continue
+		}
+		if strings.HasPrefix(vname, vprefix) && strings.HasSuffix(vname, vsuffix) {
+			ids = append(ids, vname[len(vprefix):len(vname)-len(vsuffix)])
+		}
+	}
+	return filterIDs(ids, ebg.sanitizeName(prefix)), nil
+}
@@ -2,6 +2,8 @@ package pvc
 
 import (
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -75,3 +77,81 @@ func TestEnvVarBackendGetterGetFilteredName(t *testing.T) {
 		t.Fatalf("bad value: %v (expected %v)", string(s), value)
 	}
 }
+
+func TestEnvVarBackendGetterList(t *testing.T) {
+	eb := &envVarBackend{
+		mapping: "PVCTEST_{{ .ID }}_SECRET",
+	}
+	vars := map[string]string{
+		"PVCTEST_DB_PASSWORD_SECRET": "foo",
+		"PVCTEST_DB_USERNAME_SECRET": "bar",
+		"PVCTEST_API_KEY_SECRET":     "baz",
+		"PVCTEST_UNRELATED":          "qux",
+	}
+	for k, v := range vars {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatalf("error setting env var: %v", err)
+		}
+		defer os.Unsetenv(k)
+	}
+
+	evb, err := newEnvVarBackendGetter(eb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+
+	ids, err := evb.List("db/")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"DB_PASSWORD", "DB_USERNAME"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+	ids, err = evb.List("")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"API_KEY", "DB_PASSWORD", "DB_USERNAME"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+}
+
+func TestEnvVarBackendGetterGetNotFoundIncludesID(t *testing.T) {
+	eb := &envVarBackend{
+		mapping: "SECRET_MYAPP_{{ .ID }}",
+	}
+	evb, err := newEnvVarBackendGetter(eb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	_, err = evb.Get("db/password")
+	if err == nil {
+		t.Fatalf("should have failed")
+	}
+	if !strings.Contains(err.Error(), "SECRET_MYAPP_DB_PASSWORD") || !strings.Contains(err.Error(), "db/password") {
+		t.Fatalf("error should contain variable name and id: %v", err)
+	}
+}
+
+func TestEnvVarBackendGetterGetSpecialCharacters(t *testing.T) {
+	eb := &envVarBackend{
+		mapping: "SECRET_{{ .ID }}",
+	}
+	envvar := "SECRET_A_B_C_D_E"
+	if err := os.Setenv(envvar, "foo"); err != nil {
+		t.Fatalf("error setting env var: %v", err)
+	}
+	defer os.Unsetenv(envvar)
+
+	evb, err := newEnvVarBackendGetter(eb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	s, err := evb.Get("a&b<c'd+e")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(s) != "foo" {
+		t.Fatalf("bad value: %v", string(s))
+	}
+}
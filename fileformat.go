@@ -0,0 +1,107 @@
+package pvc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileTreeSelectorSeparator separates the file path from the key to select within the file in a mapped file tree
+// location (eg, "db/creds.json#password")
+const FileTreeSelectorSeparator = "#"
+
+// FileFormat enumerates the formats of structured secret files in the file tree backend
+type FileFormat int
+
+// Supported file formats
+const (
+	AutoFileFormat   FileFormat = iota // JSON if the file name ends in ".json", otherwise dotenv
+	JSONFileFormat                     // A single JSON object
+	DotenvFileFormat                   // KEY=VALUE lines, optionally prefixed with "export" and with quoted values
+)
+
+// splitSelector splits a mapped file tree location into the file path and the selected key (empty if none)
+func splitSelector(location string) (string, string) {
+	i := strings.LastIndex(location, FileTreeSelectorSeparator)
+	if i < 0 {
+		return location, ""
+	}
+	return location[:i], location[i+len(FileTreeSelectorSeparator):]
+}
+
+// selectKey parses contents of the file at path according to format and returns the value of key
+func selectKey(contents []byte, path string, format FileFormat, key string) ([]byte, error) {
+	if format == AutoFileFormat {
+		format = DotenvFileFormat
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			format = JSONFileFormat
+		}
+	}
+	switch format {
+	case JSONFileFormat:
+		return selectJSONKey(contents, key)
+	case DotenvFileFormat:
+		return selectDotenvKey(contents, key)
+	default:
+		return nil, fmt.Errorf("unknown file format: %v", format)
+	}
+}
+
+// selectJSONKey returns the value of key in a JSON object. String values are returned literally, any other type is returned
+// as JSON.
+func selectJSONKey(contents []byte, key string) ([]byte, error) {
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(contents, &obj); err != nil {
+		return nil, fmt.Errorf("error decoding file (must be a JSON object): %v", err)
+	}
+	raw, ok := obj[key]
+	if !ok {
+		return nil, fmt.Errorf("key not found in file: %v", key)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s), nil
+	}
+	return raw, nil
+}
+
+// selectDotenvKey returns the value of key in KEY=VALUE lines. Blank lines and lines beginning with "#" are ignored.
+// Double-quoted values are unescaped, single-quoted values are returned literally.
+func selectDotenvKey(contents []byte, key string) ([]byte, error) {
+	s := bufio.NewScanner(bytes.NewReader(contents))
+	s.Buffer(nil, len(contents)+1)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed line %v: missing '='", n)
+		}
+		if strings.TrimSpace(k) != key {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch {
+		case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+			uv, err := strconv.Unquote(v)
+			if err != nil {
+				return nil, fmt.Errorf("malformed quoted value on line %v: %v", n, err)
+			}
+			v = uv
+		case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+			v = v[1 : len(v)-1]
+		}
+		return []byte(v), nil
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	return nil, fmt.Errorf("key not found in file: %v", key)
+}
@@ -0,0 +1,60 @@
+package pvc
+
+import "testing"
+
+func TestSelectKey(t *testing.T) {
+	jsonContents := []byte(`{"username": "frank", "password": "p@ss=word", "port": 5432, "opts": {"ssl": true}}`)
+	dotenvContents := []byte(`# rendered by vault agent
+export DB_USERNAME=frank
+DB_PASSWORD="p@ss=word\n"
+DB_HOST = 'db.example.com'
+
+EMPTY=
+`)
+	tests := []struct {
+		name     string
+		contents []byte
+		path     string
+		format   FileFormat
+		key      string
+		want     string
+		wantErr  bool
+	}{
+		{name: "json auto", contents: jsonContents, path: "creds.json", key: "password", want: "p@ss=word"},
+		{name: "json number", contents: jsonContents, path: "creds.json", key: "port", want: "5432"},
+		{name: "json object", contents: jsonContents, path: "creds.json", key: "opts", want: `{"ssl": true}`},
+		{name: "json explicit", contents: jsonContents, path: "creds", format: JSONFileFormat, key: "username", want: "frank"},
+		{name: "json missing key", contents: jsonContents, path: "creds.json", key: "missing", wantErr: true},
+		{name: "json invalid", contents: dotenvContents, path: "creds.json", key: "DB_USERNAME", wantErr: true},
+		{name: "dotenv export", contents: dotenvContents, path: "creds.env", key: "DB_USERNAME", want: "frank"},
+		{name: "dotenv double quoted", contents: dotenvContents, path: "creds", key: "DB_PASSWORD", want: "p@ss=word\n"},
+		{name: "dotenv single quoted", contents: dotenvContents, path: "creds", key: "DB_HOST", want: "db.example.com"},
+		{name: "dotenv empty", contents: dotenvContents, path: "creds", key: "EMPTY", want: ""},
+		{name: "dotenv explicit", contents: dotenvContents, path: "creds.json", format: DotenvFileFormat, key: "DB_USERNAME", want: "frank"},
+		{name: "dotenv missing key", contents: dotenvContents, path: "creds", key: "missing", wantErr: true},
+		{name: "dotenv malformed", contents: []byte("FOO\n"), path: "creds", key: "FOO", wantErr: true},
+		{name: "unknown format", contents: jsonContents, path: "creds", format: FileFormat(99), key: "username", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectKey(tt.contents, tt.path, tt.format, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Fatalf("bad value: %q (expected %q)", string(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSelector(t *testing.T) {
+	path, key := splitSelector("db/creds.json#password")
+	if path != "db/creds.json" || key != "password" {
+		t.Fatalf("bad split: %v, %v", path, key)
+	}
+	path, key = splitSelector("db/password")
+	if path != "db/password" || key != "" {
+		t.Fatalf("bad split: %v, %v", path, key)
+	}
+}
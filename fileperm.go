@@ -0,0 +1,62 @@
+package pvc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// FilePermissionCheck enumerates how file-based backends treat secret files with unsafe permissions
+type FilePermissionCheck int
+
+// Supported file permission checks
+const (
+	FilePermissionsIgnore FilePermissionCheck = iota // Don't check permissions (default)
+	FilePermissionsWarn                              // Log a warning and read the file anyway
+	FilePermissionsStrict                            // Refuse to read the file
+)
+
+// FilePermissionError is returned when a secret file is group or world accessible, or not owned by the current user
+type FilePermissionError struct {
+	Path  string      // path of the secret file
+	Mode  fs.FileMode // permissions of the secret file
+	UID   int         // owner of the secret file
+	Owner bool        // the file is not owned by the current user (otherwise Mode is too permissive)
+}
+
+func (e *FilePermissionError) Error() string {
+	if e.Owner {
+		return fmt.Sprintf("secret file %v is owned by uid %v, not the current user (uid %v)", e.Path, e.UID, os.Getuid())
+	}
+	return fmt.Sprintf("secret file %v has unsafe permissions %#o (%v): must not be accessible by group or others", e.Path, e.Mode.Perm(), e.Mode.Perm())
+}
+
+// unsafeFileModeBits are the permission bits that must not be set on secret files
+const unsafeFileModeBits fs.FileMode = 0066
+
+// checkFilePermissions verifies that the secret file described by info is readable and writable only by the current user
+// according to check. It returns a *FilePermissionError if check is FilePermissionsStrict and the file is unsafe, and logs
+// a warning to logger if check is FilePermissionsWarn. Permissions are only checked on Unix platforms.
+func checkFilePermissions(path string, info fs.FileInfo, check FilePermissionCheck, logger Logger) error {
+	if check == FilePermissionsIgnore {
+		return nil
+	}
+	uid, ok := fileOwner(info)
+	if !ok {
+		return nil
+	}
+	var perr *FilePermissionError
+	switch {
+	case info.Mode().Perm()&unsafeFileModeBits != 0:
+		perr = &FilePermissionError{Path: path, Mode: info.Mode(), UID: uid}
+	case uid != os.Getuid():
+		perr = &FilePermissionError{Path: path, Mode: info.Mode(), UID: uid, Owner: true}
+	default:
+		return nil
+	}
+	if check == FilePermissionsStrict {
+		return perr
+	}
+	loggerOrDefault(logger).Warn("unsafe secret file permissions", "path", path, "error", perr)
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build unix
+
+package pvc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFilePermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    os.FileMode
+		check   FilePermissionCheck
+		wantErr bool
+	}{
+		{name: "owner only strict", mode: 0600, check: FilePermissionsStrict},
+		{name: "owner read only strict", mode: 0400, check: FilePermissionsStrict},
+		{name: "group readable strict", mode: 0640, check: FilePermissionsStrict, wantErr: true},
+		{name: "world readable strict", mode: 0644, check: FilePermissionsStrict, wantErr: true},
+		{name: "world writable strict", mode: 0602, check: FilePermissionsStrict, wantErr: true},
+		{name: "world readable warn", mode: 0644, check: FilePermissionsWarn},
+		{name: "world readable ignore", mode: 0644, check: FilePermissionsIgnore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "secret")
+			if err := os.WriteFile(p, []byte("foo"), tt.mode); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+			if err := os.Chmod(p, tt.mode); err != nil {
+				t.Fatalf("error setting mode: %v", err)
+			}
+			info, err := os.Stat(p)
+			if err != nil {
+				t.Fatalf("error getting file stat: %v", err)
+			}
+			err = checkFilePermissions(p, info, tt.check, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkFilePermissions(, nil) error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var perr *FilePermissionError
+			if err != nil && (!errors.As(err, &perr) || perr.Path != p || perr.Mode.Perm() != tt.mode) {
+				t.Fatalf("bad error: %#v", err)
+			}
+		})
+	}
+}
+
+func TestCheckFilePermissionsOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skipf("changing file ownership requires root, skipping")
+	}
+	p := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(p, []byte("foo"), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.Chown(p, 65534, 65534); err != nil {
+		t.Fatalf("error changing owner: %v", err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("error getting file stat: %v", err)
+	}
+	err = checkFilePermissions(p, info, FilePermissionsStrict, nil)
+	var perr *FilePermissionError
+	if !errors.As(err, &perr) || !perr.Owner || perr.UID != 65534 {
+		t.Fatalf("expected owner FilePermissionError, received: %v", err)
+	}
+}
+
+func TestNewSecretsClientFilePermissionCheck(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "password"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(root, "password"), 0644); err != nil {
+		t.Fatalf("error setting mode: %v", err)
+	}
+	sc, err := NewSecretsClient(WithFileTreeBackend(root), WithFilePermissionCheck(FilePermissionsStrict))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	_, err = sc.Get("password")
+	var perr *FilePermissionError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected FilePermissionError, received: %v", err)
+	}
+
+	jf := filepath.Join(root, "secrets.json")
+	if err := os.WriteFile(jf, []byte(`{"foo": "bar"}`), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.Chmod(jf, 0644); err != nil {
+		t.Fatalf("error setting mode: %v", err)
+	}
+	_, err = NewSecretsClient(WithJSONFileBackend(jf), WithFilePermissionCheck(FilePermissionsStrict))
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected FilePermissionError, received: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package pvc
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileOwner returns the uid of the owner of the file described by info
+func fileOwner(info fs.FileInfo) (int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, false
+	}
+	return int(st.Uid), true
+}
@@ -1,10 +1,15 @@
 package pvc
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Default mapping for this backend
@@ -13,11 +18,32 @@ const (
 	DefaultFileTreeRootPath = "/vault/secrets"
 )
 
-// MaxFileTreeSizeBytes indicates the maximum file size we will read
+// MaxFileTreeFileSizeBytes is the default maximum size of secret values for clients that don't set WithMaxSecretSize.
+//
+// Deprecated: Use WithMaxSecretSize to set the limit per client.
 var MaxFileTreeFileSizeBytes int64 = 2_000_000 // 2 MB
 
+// FileTreePathError is returned when a mapped file tree path resolves outside of the root path, or traverses a symlink
+// when symlinks are denied (see WithFileTreeDenySymlinks)
+type FileTreePathError struct {
+	Path     string // mapped path
+	Resolved string // Path with symlinks resolved (empty if the path was rejected before resolution)
+	Root     string // root path
+	Symlink  bool   // Path traverses a symlink and symlinks are denied
+}
+
+func (e *FileTreePathError) Error() string {
+	if e.Symlink {
+		return fmt.Sprintf("file tree path traverses a symlink (symlinks not allowed): %v -> %v", e.Path, e.Resolved)
+	}
+	if e.Resolved != "" && e.Resolved != e.Path {
+		return fmt.Sprintf("file tree path resolves outside of root %v: %v -> %v", e.Root, e.Path, e.Resolved)
+	}
+	return fmt.Sprintf("file tree path is outside of root %v: %v", e.Root, e.Path)
+}
+
 type fileTreeBackendGetter struct {
-	mapper   SecretMapper
+	mapper   *secretMapper
 	config   *fileTreeBackend
 	rootPath string
 }
@@ -26,10 +52,12 @@ func newFileTreeBackendGetter(ft *fileTreeBackend) (*fileTreeBackendGetter, erro
 	if ft.mapping == "" {
 		ft.mapping = DefaultFileTreeMapping
 	}
-	sm, err := newSecretMapper(ft.mapping)
+	sm, err := newSecretMapper(ft.mapping, ft.mappingVars)
 	if err != nil {
 		return nil, fmt.Errorf("file tree error with mapping: %v", err)
 	}
+	// IDs must be relative paths without "." or ".." segments so they can't refer outside the root path
+	sm.escapeID = validatePathID
 	if ft.rootPath == "" {
 		ft.rootPath = DefaultFileTreeRootPath
 	}
@@ -39,31 +67,180 @@ func newFileTreeBackendGetter(ft *fileTreeBackend) (*fileTreeBackendGetter, erro
 	}, nil
 }
 
-func (ftg *fileTreeBackendGetter) Get(id string) ([]byte, error) {
+// open resolves and opens the file for id and returns it along with any key selector in the mapped location (if
+// selectors are enabled)
+func (ftg *fileTreeBackendGetter) open(id string) (*os.File, string, error) {
 	key, err := ftg.mapper.MapSecret(id)
 	if err != nil {
-		return nil, fmt.Errorf("error mapping secret id to filetree path: %v", err)
+		return nil, "", fmt.Errorf("error mapping secret id to filetree path: %v", err)
+	}
+	var selector string
+	if ftg.config.selectors {
+		key, selector = splitSelector(key)
 	}
 	secretFilePath := filepath.Join(ftg.config.rootPath, key)
 	if !filepath.IsAbs(secretFilePath) {
-		return nil, fmt.Errorf("filetree path must be absolute: %v", secretFilePath)
+		return nil, "", fmt.Errorf("filetree path must be absolute: %v", secretFilePath)
+	}
+	secretFilePath, err = ftg.resolve(secretFilePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("file tree error resolving path (id: %v): %w", id, err)
 	}
 	f, err := os.Open(secretFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("file tree error opening file %v: %v", secretFilePath, err)
+		return nil, "", fmt.Errorf("file tree error opening file %v (id: %v): %v", secretFilePath, id, err)
 	}
-	defer f.Close()
 	stat, err := f.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("error getting file stat: %v", err)
+		f.Close()
+		return nil, "", fmt.Errorf("error getting file stat: %v", err)
+	}
+	if err := checkFilePermissions(secretFilePath, stat, ftg.config.permissionCheck, ftg.config.logger); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("error checking file permissions (id: %v): %w", id, err)
 	}
 	size := stat.Size()
-	if size > MaxFileTreeFileSizeBytes {
-		return nil, fmt.Errorf("file too large (max: %v bytes): %v", MaxFileTreeFileSizeBytes, size)
+	if max := ftg.maxSize(); size > max {
+		f.Close()
+		return nil, "", fmt.Errorf("file too large (max: %v bytes): %v: %w", max, size, ErrSecretTooLarge)
+	}
+	return f, selector, nil
+}
+
+// maxSize returns the maximum file size to read
+func (ftg *fileTreeBackendGetter) maxSize() int64 {
+	if ftg.config.maxSize > 0 {
+		return ftg.config.maxSize
 	}
-	c, err := ioutil.ReadAll(f)
+	return MaxFileTreeFileSizeBytes
+}
+
+func (ftg *fileTreeBackendGetter) Get(id string) ([]byte, error) {
+	f, selector, err := ftg.open(id)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file: %v", err)
+		return nil, err
+	}
+	defer f.Close()
+	return ftg.read(f, selector, id)
+}
+
+// read reads the opened secret file and returns either its contents or the value of the selected key
+func (ftg *fileTreeBackendGetter) read(f *os.File, selector, id string) ([]byte, error) {
+	c, err := ioutil.ReadAll(&limitedReadCloser{rc: f, max: ftg.maxSize()})
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	if selector != "" {
+		c, err = selectKey(c, f.Name(), ftg.config.format, selector)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting key in file %v (id: %v): %w", f.Name(), id, err)
+		}
 	}
 	return c, nil
 }
+
+// GetReader returns the opened secret file without reading it. If the mapped location selects a key within the file,
+// the file is parsed and a reader over the selected value is returned.
+func (ftg *fileTreeBackendGetter) GetReader(id string) (io.ReadCloser, error) {
+	f, selector, err := ftg.open(id)
+	if err != nil {
+		return nil, err
+	}
+	if selector != "" {
+		defer f.Close()
+		v, err := ftg.read(f, selector, id)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(v)), nil
+	}
+	return &limitedReadCloser{rc: f, max: ftg.maxSize()}, nil
+}
+
+// List walks the directory tree under the root path and returns the IDs of all files matching the mapping.
+// Directories beginning with ".." (such as the Kubernetes "..data" atomic writer directories) are skipped. Keys within
+// files are not listed, so nothing is returned for mappings that select a key (eg, "db/creds.json#{{ .ID }}").
+func (ftg *fileTreeBackendGetter) List(prefix string) ([]string, error) {
+	lp, err := ftg.mapper.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	startDir := ftg.config.rootPath
+	if i := strings.LastIndex(lp, "/"); i >= 0 {
+		startDir = filepath.Join(ftg.config.rootPath, filepath.FromSlash(lp[:i]))
+	}
+	if !withinRoot(ftg.config.rootPath, startDir) {
+		return nil, &FileTreePathError{Path: startDir, Root: ftg.config.rootPath}
+	}
+	ids := []string{}
+	err = filepath.WalkDir(startDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == startDir {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != startDir && strings.HasPrefix(d.Name(), "..") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if _, err := ftg.resolve(path); err != nil {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(ftg.config.rootPath, path)
+		if err != nil {
+			return nil
+		}
+		if id, err := ftg.mapper.UnmapSecret(filepath.ToSlash(rel)); err == nil {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking file tree: %v", err)
+	}
+	return filterIDs(ids, prefix), nil
+}
+
+// resolve returns path with any symlinks resolved, or a *FileTreePathError if path is outside of the root path, or traverses
+// a symlink that is either denied or resolves outside of the root path
+func (ftg *fileTreeBackendGetter) resolve(path string) (string, error) {
+	root := ftg.config.rootPath
+	if !withinRoot(root, path) {
+		return "", &FileTreePathError{Path: path, Root: root}
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("error resolving root path: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, _ := filepath.Rel(root, path)
+	if resolved == filepath.Join(realRoot, rel) {
+		return resolved, nil
+	}
+	if ftg.config.denySymlinks {
+		return "", &FileTreePathError{Path: path, Resolved: resolved, Root: root, Symlink: true}
+	}
+	if !withinRoot(realRoot, resolved) {
+		return "", &FileTreePathError{Path: path, Resolved: resolved, Root: root}
+	}
+	return resolved, nil
+}
+
+// withinRoot lexically determines whether path is root or a descendant of root
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
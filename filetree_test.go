@@ -1,8 +1,12 @@
 package pvc
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -65,3 +69,309 @@ func TestFileTreeBackendGetter_Get_FileTooLarge(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestFileTreeBackendGetterList(t *testing.T) {
+	root := t.TempDir()
+	files := []string{"db/password.txt", "db/username.txt", "api/key.txt", "..data/hidden.txt", "notes"}
+	for _, f := range files {
+		p := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0600); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+	tb := &fileTreeBackend{
+		rootPath: root,
+		mapping:  "{{ .ID }}.txt",
+	}
+	tbg, err := newFileTreeBackendGetter(tb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	ids, err := tbg.List("")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"api/key", "db/password", "db/username"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+	ids, err = tbg.List("db/p")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"db/password"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+	ids, err = tbg.List("missing/")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("expected no ids: %v", ids)
+	}
+}
+
+func TestFileTreeBackendGetterGetSpecialCharacters(t *testing.T) {
+	root := t.TempDir()
+	name := "p&ss+w'rd <1>"
+	if err := os.WriteFile(filepath.Join(root, name), []byte("foo"), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	tb := &fileTreeBackend{
+		rootPath: root,
+	}
+	tbg, err := newFileTreeBackendGetter(tb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	s, err := tbg.Get(name)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(s) != "foo" {
+		t.Fatalf("bad value: %v", string(s))
+	}
+	_, err = tbg.Get("../" + filepath.Base(root) + "/" + name)
+	if err == nil {
+		t.Fatalf("relative path segments should have been rejected")
+	}
+}
+
+// k8sSecretVolume creates a directory laid out like a Kubernetes secret volume: each file is a symlink into "..data",
+// which is itself a symlink to a timestamped directory
+func k8sSecretVolume(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	ts := filepath.Join(root, "..2023_01_01_00_00_00.000000000")
+	if err := os.Mkdir(ts, 0700); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	for name, v := range files {
+		if err := os.WriteFile(filepath.Join(ts, name), []byte(v), 0600); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(root, name)); err != nil {
+			t.Fatalf("error creating symlink: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Base(ts), filepath.Join(root, "..data")); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+	return root
+}
+
+func TestFileTreeBackendGetterGetTraversalMapping(t *testing.T) {
+	tb := &fileTreeBackend{
+		rootPath: filepath.Join(testingroot(), "missing"),
+		mapping:  "../{{ .ID }}",
+	}
+	tbg, err := newFileTreeBackendGetter(tb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	_, err = tbg.Get("username")
+	var pe *FileTreePathError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected FileTreePathError, received: %v", err)
+	}
+}
+
+func TestFileTreeBackendGetterGetSymlinks(t *testing.T) {
+	root := k8sSecretVolume(t, map[string]string{"password": "foo"})
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := os.WriteFile(outside, []byte("bar"), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+
+	// symlinks within the root are followed by default
+	tbg, err := newFileTreeBackendGetter(&fileTreeBackend{rootPath: root})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	s, err := tbg.Get("password")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(s) != "foo" {
+		t.Fatalf("bad value: %v", string(s))
+	}
+	var pe *FileTreePathError
+	_, err = tbg.Get("escape")
+	if !errors.As(err, &pe) || pe.Symlink {
+		t.Fatalf("expected outside of root FileTreePathError, received: %v", err)
+	}
+	ids, err := tbg.List("")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"password"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+
+	tbg, err = newFileTreeBackendGetter(&fileTreeBackend{rootPath: root, denySymlinks: true})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	_, err = tbg.Get("password")
+	if !errors.As(err, &pe) || !pe.Symlink {
+		t.Fatalf("expected symlink FileTreePathError, received: %v", err)
+	}
+	ids, err = tbg.List("")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("symlinks should not be listed: %v", ids)
+	}
+}
+
+func TestNewSecretsClientFileTreeSymlinks(t *testing.T) {
+	root := k8sSecretVolume(t, map[string]string{"password": "foo"})
+	sc, err := NewSecretsClient(WithFileTreeBackend(root))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	s, err := sc.Get("password")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(s) != "foo" {
+		t.Fatalf("bad value: %v", string(s))
+	}
+
+	sc, err = NewSecretsClient(WithFileTreeDenySymlinks(), WithFileTreeBackend(root))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	var pe *FileTreePathError
+	if _, err := sc.Get("password"); !errors.As(err, &pe) || !pe.Symlink {
+		t.Fatalf("expected symlink FileTreePathError, received: %v", err)
+	}
+}
+
+func TestFileTreeBackendGetterGetSelector(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "db"), 0700); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "db", "creds.json"), []byte(`{"username": "frank", "password": "foo"}`), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "db", "creds"), []byte("username=frank\npassword=foo\n"), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	tests := []struct {
+		name    string
+		mapping string
+		format  FileFormat
+		id      string
+	}{
+		{name: "selector in id", mapping: "{{ .ID }}", id: "db/creds.json#password"},
+		{name: "selector in mapping", mapping: "db/creds.json#{{ .ID }}", id: "password"},
+		{name: "dotenv", mapping: "db/creds#{{ .ID }}", id: "password"},
+		{name: "explicit format", mapping: "db/creds.json#{{ .ID }}", format: JSONFileFormat, id: "password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbg, err := newFileTreeBackendGetter(&fileTreeBackend{rootPath: root, mapping: tt.mapping, format: tt.format, selectors: true})
+			if err != nil {
+				t.Fatalf("should have succeeded: %v", err)
+			}
+			s, err := tbg.Get(tt.id)
+			if err != nil {
+				t.Fatalf("get failed: %v", err)
+			}
+			if string(s) != "foo" {
+				t.Fatalf("bad value: %v", string(s))
+			}
+		})
+	}
+}
+
+func TestFileTreeBackendGetterGetHashInFileName(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "api#key"), []byte("foo"), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	// without selectors, "#" is part of the file name
+	tbg, err := newFileTreeBackendGetter(&fileTreeBackend{rootPath: root})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	s, err := tbg.Get("api#key")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(s) != "foo" {
+		t.Fatalf("bad value: %v", string(s))
+	}
+	ids, err := tbg.List("")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"api#key"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+
+	tbg, err = newFileTreeBackendGetter(&fileTreeBackend{rootPath: root, selectors: true})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	if _, err := tbg.Get("api#key"); err == nil {
+		t.Fatalf("expected error reading key from missing file")
+	}
+}
+
+func TestFileTreeBackendGetterGetReader(t *testing.T) {
+	root := t.TempDir()
+	contents := bytes.Repeat([]byte("x"), 3_000_000)
+	if err := os.WriteFile(filepath.Join(root, "keystore.jks"), contents, 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	tbg, err := newFileTreeBackendGetter(&fileTreeBackend{rootPath: root})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	_, err = tbg.GetReader("keystore.jks")
+	if !errors.Is(err, ErrSecretTooLarge) {
+		t.Fatalf("expected ErrSecretTooLarge, received: %v", err)
+	}
+
+	tbg, err = newFileTreeBackendGetter(&fileTreeBackend{rootPath: root, maxSize: 4_000_000})
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	r, err := tbg.GetReader("keystore.jks")
+	if err != nil {
+		t.Fatalf("get reader failed: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(got, contents) {
+		t.Fatalf("bad contents: length %v", len(got))
+	}
+}
+
+func TestNewSecretsClientMaxSecretSize(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "password"), []byte("foobar"), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	sc, err := NewSecretsClient(WithFileTreeBackend(root), WithMaxSecretSize(3))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if _, err := sc.Get("password"); !errors.Is(err, ErrSecretTooLarge) {
+		t.Fatalf("expected ErrSecretTooLarge, received: %v", err)
+	}
+	if _, err := sc.GetReader("password"); !errors.Is(err, ErrSecretTooLarge) {
+		t.Fatalf("expected ErrSecretTooLarge, received: %v", err)
+	}
+}
@@ -12,7 +12,7 @@ const (
 )
 
 type jsonFileBackendGetter struct {
-	mapper   SecretMapper
+	mapper   *secretMapper
 	config   *jsonFileBackend
 	contents map[string]string
 }
@@ -23,6 +23,13 @@ func newjsonFileBackendGetter(jb *jsonFileBackend) (*jsonFileBackendGetter, erro
 		return nil, fmt.Errorf("error opening file: %v", err)
 	}
 	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error getting file stat: %v", err)
+	}
+	if err := checkFilePermissions(jb.fileLocation, stat, jb.permissionCheck, jb.logger); err != nil {
+		return nil, fmt.Errorf("error checking file permissions: %w", err)
+	}
 	c := map[string]string{}
 	d := json.NewDecoder(f)
 	err = d.Decode(&c)
@@ -32,7 +39,7 @@ func newjsonFileBackendGetter(jb *jsonFileBackend) (*jsonFileBackendGetter, erro
 	if jb.mapping == "" {
 		jb.mapping = DefaultJSONFileMapping
 	}
-	sm, err := newSecretMapper(jb.mapping)
+	sm, err := newSecretMapper(jb.mapping, jb.mappingVars)
 	if err != nil {
 		return nil, fmt.Errorf("error with mapping: %v", err)
 	}
@@ -51,5 +58,18 @@ func (jbg *jsonFileBackendGetter) Get(id string) ([]byte, error) {
 	if val, ok := jbg.contents[key]; ok {
 		return []byte(val), nil
 	}
-	return nil, fmt.Errorf("secret not found: %v", key)
+	return nil, fmt.Errorf("secret not found: %v (id: %v)", key, id)
+}
+
+func (jbg *jsonFileBackendGetter) List(prefix string) ([]string, error) {
+	if _, err := jbg.mapper.listPrefix(prefix); err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for key := range jbg.contents {
+		if id, err := jbg.mapper.UnmapSecret(key); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return filterIDs(ids, prefix), nil
 }
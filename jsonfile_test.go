@@ -1,6 +1,11 @@
 package pvc
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
 
 func TestNewjsonFileBackendGetter(t *testing.T) {
 	jb := &jsonFileBackend{
@@ -30,3 +35,48 @@ func TestJSONFileBackendGetterGet(t *testing.T) {
 		t.Fatalf("bad value: %v (expected %v)", string(s), value)
 	}
 }
+
+func TestJSONFileBackendGetterList(t *testing.T) {
+	jb := &jsonFileBackend{
+		fileLocation: "example/secrets.json",
+	}
+	jbg, err := newjsonFileBackendGetter(jb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	ids, err := jbg.List("")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"biz", "foo"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+	ids, err = jbg.List("f")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"foo"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+}
+
+func TestJSONFileBackendGetterGetSpecialCharacters(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "secrets.json")
+	if err := os.WriteFile(f, []byte(`{"a&b<c'd+e": "foo"}`), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	jb := &jsonFileBackend{
+		fileLocation: f,
+	}
+	jbg, err := newjsonFileBackendGetter(jb)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	s, err := jbg.Get("a&b<c'd+e")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(s) != "foo" {
+		t.Fatalf("bad value: %v", string(s))
+	}
+}
@@ -0,0 +1,83 @@
+package pvc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives log output from a SecretsClient. Arguments after the message are alternating keys and values, as for
+// *slog.Logger, which satisfies this interface. Secret values are never logged.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// WithLogger sets the logger used by the client and its backend (default: the standard log package, without debug
+// messages). Use NopLogger to disable logging.
+func WithLogger(logger Logger) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.logger = logger
+	}
+}
+
+// NopLogger discards all log output
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
+
+// stdLogger writes to the standard log package as "LEVEL msg key=value ...", ignoring debug messages
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, args ...any) {}
+func (stdLogger) Info(msg string, args ...any)  { stdLog("INFO", msg, args) }
+func (stdLogger) Warn(msg string, args ...any)  { stdLog("WARN", msg, args) }
+func (stdLogger) Error(msg string, args ...any) { stdLog("ERROR", msg, args) }
+
+func stdLog(level, msg string, args []any) {
+	var b strings.Builder
+	b.WriteString(level + " " + msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	log.Print(b.String())
+}
+
+// attrLogger adds attributes (such as the backend name) to every message
+type attrLogger struct {
+	l     Logger
+	attrs []any
+}
+
+func (a attrLogger) with(args []any) []any {
+	return append(a.attrs[:len(a.attrs):len(a.attrs)], args...)
+}
+
+func (a attrLogger) Debug(msg string, args ...any) { a.l.Debug(msg, a.with(args)...) }
+func (a attrLogger) Info(msg string, args ...any)  { a.l.Info(msg, a.with(args)...) }
+func (a attrLogger) Warn(msg string, args ...any)  { a.l.Warn(msg, a.with(args)...) }
+func (a attrLogger) Error(msg string, args ...any) { a.l.Error(msg, a.with(args)...) }
+
+// withLogAttrs returns a logger that adds attrs to every message logged to l (or the default logger if l is nil)
+func withLogAttrs(l Logger, attrs ...any) Logger {
+	return attrLogger{l: loggerOrDefault(l), attrs: attrs}
+}
+
+// loggerOrDefault returns l, or the default logger if l is nil
+func loggerOrDefault(l Logger) Logger {
+	if l == nil {
+		return stdLogger{}
+	}
+	return l
+}
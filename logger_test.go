@@ -0,0 +1,129 @@
+package pvc
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testLogEntry struct {
+	level string
+	msg   string
+	args  []any
+}
+
+// testLogger records log messages
+type testLogger struct {
+	sync.Mutex
+	entries []testLogEntry
+}
+
+func (tl *testLogger) log(level, msg string, args []any) {
+	tl.Lock()
+	defer tl.Unlock()
+	tl.entries = append(tl.entries, testLogEntry{level: level, msg: msg, args: args})
+}
+
+func (tl *testLogger) Debug(msg string, args ...any) { tl.log("DEBUG", msg, args) }
+func (tl *testLogger) Info(msg string, args ...any)  { tl.log("INFO", msg, args) }
+func (tl *testLogger) Warn(msg string, args ...any)  { tl.log("WARN", msg, args) }
+func (tl *testLogger) Error(msg string, args ...any) { tl.log("ERROR", msg, args) }
+
+var _ Logger = &testLogger{}
+
+func TestWithLoggerFilePermissionWarning(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "password"), []byte("s3cr3t"), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(root, "password"), 0644); err != nil {
+		t.Fatalf("error setting mode: %v", err)
+	}
+	tl := &testLogger{}
+	sc, err := NewSecretsClient(
+		WithFileTreeBackend(root),
+		WithFilePermissionCheck(FilePermissionsWarn),
+		WithLogger(tl),
+	)
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if _, err := sc.Get("password"); err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if len(tl.entries) != 1 {
+		t.Fatalf("expected one log entry: %+v", tl.entries)
+	}
+	e := tl.entries[0]
+	if e.level != "WARN" || len(e.args) < 4 || !reflect.DeepEqual(e.args[:4], []any{"backend", "filetree", "path", filepath.Join(root, "password")}) {
+		t.Fatalf("bad log entry: %+v", e)
+	}
+	if strings.Contains(fmt.Sprint(e.args...), "s3cr3t") {
+		t.Fatalf("log entry contains the secret value: %+v", e)
+	}
+}
+
+func TestSecretsClientLookupStaleLogging(t *testing.T) {
+	var down bool
+	fb := &fakeBackend{
+		GetFunc: func(id string) ([]byte, error) {
+			if down {
+				return nil, ErrCircuitOpen
+			}
+			return []byte("s3cr3t"), nil
+		},
+	}
+	tl := &testLogger{}
+	stale, err := newStaleCache(0, "", nil, tl)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	sc := &SecretsClient{backend: fb, stale: stale, logger: withLogAttrs(tl, "backend", "test")}
+	if _, err := sc.Get("foo"); err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	down = true
+	if _, err := sc.Get("foo"); err != nil {
+		t.Fatalf("error getting stale value: %v", err)
+	}
+	if len(tl.entries) != 1 {
+		t.Fatalf("expected one log entry: %+v", tl.entries)
+	}
+	e := tl.entries[0]
+	if e.level != "WARN" || !reflect.DeepEqual(e.args[:4], []any{"backend", "test", "id", "foo"}) {
+		t.Fatalf("bad log entry: %+v", e)
+	}
+	if strings.Contains(fmt.Sprint(e.args...), "s3cr3t") {
+		t.Fatalf("log entry contains the secret value: %+v", e)
+	}
+}
+
+func TestDefaultLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+
+	l := withLogAttrs(nil, "backend", "vault")
+	l.Debug("ignored")
+	l.Warn("auth failed", "attempt", 1, "odd")
+	if got := buf.String(); got != "WARN auth failed backend=vault attempt=1 odd\n" {
+		t.Fatalf("bad output: %q", got)
+	}
+
+	buf.Reset()
+	withLogAttrs(NopLogger, "backend", "vault").Error("discarded")
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
@@ -2,27 +2,172 @@ package pvc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"html/template"
+	"io"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
 )
 
+// ErrSecretTooLarge is returned when a secret value exceeds the maximum size (see WithMaxSecretSize)
+var ErrSecretTooLarge = errors.New("secret too large")
+
 // SecretsClient is the client that retrieves secret values
 type SecretsClient struct {
-	backend secretBackend
+	backend      secretBackend
+	maxSize      int64
+	transforms   []Transformer
+	idTransforms map[string][]Transformer
+	stale        *staleCache // nil unless WithStaleIfError
+	logger       Logger
+
+	// initialization of a backend that was unavailable when the client was created (see WithStaleIfError)
+	backendMu    sync.Mutex
+	init         func() (secretBackend, error)
+	initializing bool
+	initAttempt  time.Time
+	initErr      error
 }
 
-// Get returns the value of a secret from the configured backend
-func (sc *SecretsClient) Get(id string) ([]byte, error) {
-	if sc.backend == nil {
+// backendInitInterval is the minimum interval between attempts to initialize an unavailable backend
+var backendInitInterval = 5 * time.Second
+
+// getBackend returns the backend, first initializing it if it was unavailable when the client was created
+func (sc *SecretsClient) getBackend() (secretBackend, error) {
+	sc.backendMu.Lock()
+	defer sc.backendMu.Unlock()
+	if sc.backend != nil {
+		return sc.backend, nil
+	}
+	if sc.init == nil {
 		return nil, fmt.Errorf("SecretsClient is uninitialized: backend is nil")
 	}
-	return sc.backend.Get(id)
+	if sc.initializing || time.Since(sc.initAttempt) < backendInitInterval {
+		return nil, sc.initErr
+	}
+	sc.initializing = true
+	sc.initAttempt = time.Now()
+	sc.backendMu.Unlock()
+	be, err := sc.init()
+	sc.backendMu.Lock()
+	sc.initializing = false
+	if err != nil {
+		sc.initErr = fmt.Errorf("backend unavailable: %w", err)
+		return nil, sc.initErr
+	}
+	sc.backend = be
+	return be, nil
+}
+
+// Get returns the value of a secret from the configured backend, after applying any transformers. With
+// WithStaleIfError, the last value read successfully may be returned if the backend fails (see Lookup).
+func (sc *SecretsClient) Get(id string) ([]byte, error) {
+	s, err := sc.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Value, nil
+}
+
+// process checks the size of a secret value and applies any transformers
+func (sc *SecretsClient) process(id string, v []byte) ([]byte, error) {
+	if max := sc.maxSecretSize(); int64(len(v)) > max {
+		return nil, fmt.Errorf("error getting secret %v (max: %v bytes): %v: %w", id, max, len(v), ErrSecretTooLarge)
+	}
+	return sc.transform(id, v)
+}
+
+// GetMap returns all keys of a secret that stores several keys, such as a Vault secret with "username" and "password"
+// keys. Values are returned as decoded from the backend: strings, json.Number, or nested maps and slices. The backend must
+// support multi-key secrets (currently only Vault). Transformers do not apply.
+func (sc *SecretsClient) GetMap(id string) (map[string]interface{}, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	mg, ok := be.(secretMapGetter)
+	if !ok {
+		return nil, fmt.Errorf("backend does not support multi-key secrets")
+	}
+	return mg.GetMap(id)
+}
+
+// GetReader returns a reader for the value of a secret from the configured backend, which must be closed by the caller.
+// This is intended for large values such as keystores: the file tree backend streams the file directly, other backends
+// return a reader over the value in memory. The maximum size applies as for Get; reading past it returns ErrSecretTooLarge.
+// If any transformers apply to the secret, the value is read into memory and transformed before it is returned.
+func (sc *SecretsClient) GetReader(id string) (io.ReadCloser, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	if ss, ok := be.(secretStreamer); ok && !sc.hasTransforms(id) {
+		return ss.GetReader(id)
+	}
+	v, err := sc.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(v)), nil
+}
+
+// maxSecretSize returns the maximum size of secret values for this client
+func (sc *SecretsClient) maxSecretSize() int64 {
+	if sc.maxSize > 0 {
+		return sc.maxSize
+	}
+	return MaxFileTreeFileSizeBytes
+}
+
+// List returns the IDs of all secrets in the configured backend that begin with prefix, in sorted order.
+// An empty prefix lists every secret reachable through the mapping.
+func (sc *SecretsClient) List(prefix string) ([]string, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	return be.List(prefix)
 }
 
 type secretBackend interface {
 	Get(id string) ([]byte, error)
+	List(prefix string) ([]string, error)
+}
+
+// secretMapGetter is implemented by backends that store several keys per secret
+type secretMapGetter interface {
+	GetMap(id string) (map[string]interface{}, error)
+}
+
+// secretStreamer is implemented by backends that can stream secret values without reading them into memory first
+type secretStreamer interface {
+	GetReader(id string) (io.ReadCloser, error)
+}
+
+// limitedReadCloser reads from an io.ReadCloser and returns ErrSecretTooLarge once more than max bytes have been read
+type limitedReadCloser struct {
+	rc   io.ReadCloser
+	max  int64
+	read int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	n, err := l.rc.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n, fmt.Errorf("read more than %v bytes: %w", l.max, ErrSecretTooLarge)
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.rc.Close()
 }
 
 // SecretDefinition defines a secret and how it can be accessed via the various backends
@@ -35,29 +180,69 @@ type SecretDefinition struct {
 
 type vaultBackend struct {
 	host               string
+	hosts              []string // addresses in order of preference, overriding host (see WithVaultAddresses)
 	authentication     VaultAuthentication
 	authRetries        uint
 	authRetryDelaySecs uint
+	authRetryDelay     time.Duration
+	retryPolicy        RetryPolicy
+	breakerThreshold   int
+	breakerCooldown    time.Duration
 	token              string
 	k8sjwt             string
 	k8sauthpath        string
+	k8sjwtfile         string
+	jwt                string
+	jwtauthpath        string
+	username           string
+	password           string
+	userpassauthpath   string
+	ldapauthpath       string
+	certauthpath       string
+	awsauthpath        string
+	awsserverid        string
+	awsstsendpoint     string
+	awsstsregion       string
+	gcpauthpath        string
+	gcproletype        GCPVaultRole
 	roleid             string
+	secretid           string
+	approleauthpath    string
+	wrappingtoken      string
 	mapping            string
+	mappingVars        mappingVars
 	valuekey           string
+	tls                *VaultTLSConfig
+	namespace          string
+	timeout            time.Duration
+	enabled            bool // enabled with WithVaultBackend
+	logger             Logger
+	fromEnvironment    bool
 }
 
 type envVarBackend struct {
-	mapping string
+	mapping     string
+	mappingVars mappingVars
 }
 
 type jsonFileBackend struct {
-	fileLocation string
-	mapping      string
+	fileLocation    string
+	mapping         string
+	mappingVars     mappingVars
+	permissionCheck FilePermissionCheck
+	logger          Logger
 }
 
 type fileTreeBackend struct {
-	rootPath string
-	mapping  string
+	rootPath        string
+	mapping         string
+	mappingVars     mappingVars
+	denySymlinks    bool
+	permissionCheck FilePermissionCheck
+	format          FileFormat
+	selectors       bool // mapped locations may select a key within the file (see WithFileTreeFormat)
+	maxSize         int64
+	logger          Logger
 }
 
 //go:generate stringer -type=backendType
@@ -71,21 +256,51 @@ const (
 	fileTreeBackendType
 )
 
+// name returns the name of the backend type used in log messages
+func (bt backendType) name() string {
+	switch bt {
+	case vaultBackendType:
+		return "vault"
+	case envVarBackendType:
+		return "env"
+	case jsonBackendType:
+		return "json"
+	case fileTreeBackendType:
+		return "filetree"
+	}
+	return "unknown"
+}
+
 type secretsClientConfig struct {
 	mapping         string
+	mappingVars     mappingVars
+	permissionCheck FilePermissionCheck
+	maxSecretSize   int64
+	transforms      []Transformer
+	idTransforms    map[string][]Transformer
 	backendCount    int
 	betype          backendType
 	vaultBackend    *vaultBackend
 	envVarBackend   *envVarBackend
 	jsonFileBackend *jsonFileBackend
 	fileTreeBackend *fileTreeBackend
+	staleIfError    bool
+	maxStale        time.Duration
+	staleCacheFile  string
+	staleCacheKey   []byte
+	logger          Logger
 }
 
 // SecretsClientOption defines options when creating a SecretsClient
 type SecretsClientOption func(*secretsClientConfig)
 
 // WithMapping sets the template string mapping to determine the location for each secret in the backend. The secret ID will be interpolated as ".ID".
+// Mappings are plain text templates: values are not HTML-escaped. Each backend applies its own policy to secret IDs:
+// Vault and file tree IDs are validated as relative paths, and env var names are sanitized after mapping.
+// The environment and service names are available as ".Env" and ".Service", custom variables as ".Vars.<name>", and the
+// functions upper, lower, replace, default and env may be used (see MappingFuncs).
 // Example (Vault Backend): "secret/foo/bar/{{ .ID }}".
+// Example (Vault Backend): "secret/{{ .Env }}/{{ .Service }}/{{ .ID }}".
 // Example (Env Var Backend): "MYAPP_SECRET_{{ .ID }}"
 // Example (JSON Backend): "{{ .ID }}"
 func WithMapping(mapping string) SecretsClientOption {
@@ -94,6 +309,33 @@ func WithMapping(mapping string) SecretsClientOption {
 	}
 }
 
+// WithMappingEnv sets the environment name interpolated as ".Env" in the mapping (eg, "production")
+func WithMappingEnv(env string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.mappingVars.env = env
+	}
+}
+
+// WithMappingService sets the service name interpolated as ".Service" in the mapping
+func WithMappingService(service string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.mappingVars.service = service
+	}
+}
+
+// WithMappingVars sets custom variables available in the mapping as ".Vars.<name>". It may be supplied multiple times; later values
+// for the same name take precedence. Referencing a variable that was not supplied is an error.
+func WithMappingVars(vars map[string]string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.mappingVars.custom == nil {
+			s.mappingVars.custom = map[string]string{}
+		}
+		for k, v := range vars {
+			s.mappingVars.custom[k] = v
+		}
+	}
+}
+
 // WithFileTree enables the FileTreeBackend. With this backend, PVC reads one individual file per secret ID. Sub-paths
 // under the root should be implemented with directory separators in the secret ID.
 // The path that results from the root path + secret ID mapping will be read as the secret. This must be an absolute
@@ -109,7 +351,34 @@ func WithFileTreeBackend(rootPath string) SecretsClientOption {
 	}
 }
 
-// WithVaultBackend enables the Vault backend with the requested authentication type and host (ex: https//my.vault.com:8300)
+// WithFileTreeFormat enables mapped locations to select a key within the file after FileTreeSelectorSeparator (eg,
+// "db/creds.json#password"), and sets the format used to parse such files. AutoFileFormat parses files ending in ".json"
+// as JSON objects and any other file as KEY=VALUE lines. Locations without a selector return the whole file. Without this
+// option, "#" has no special meaning in file tree locations.
+func WithFileTreeFormat(format FileFormat) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.fileTreeBackend == nil {
+			s.fileTreeBackend = &fileTreeBackend{}
+		}
+		s.fileTreeBackend.format = format
+		s.fileTreeBackend.selectors = true
+	}
+}
+
+// WithFileTreeDenySymlinks makes the file tree backend reject any path that traverses a symlink. By default, symlinks are
+// followed as long as the target remains within the root path, as required for Kubernetes secret and configmap volumes
+// (where each file is a symlink into a "..data" directory).
+func WithFileTreeDenySymlinks() SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.fileTreeBackend == nil {
+			s.fileTreeBackend = &fileTreeBackend{}
+		}
+		s.fileTreeBackend.denySymlinks = true
+	}
+}
+
+// WithVaultBackend enables the Vault backend with the requested authentication type and host (ex: https//my.vault.com:8300,
+// or unix:///var/run/vault-agent.sock for a Vault Agent listening on a Unix socket)
 func WithVaultBackend(auth VaultAuthentication, host string) SecretsClientOption {
 	return func(s *secretsClientConfig) {
 		s.betype = vaultBackendType
@@ -119,6 +388,45 @@ func WithVaultBackend(auth VaultAuthentication, host string) SecretsClientOption
 		}
 		s.vaultBackend.authentication = auth
 		s.vaultBackend.host = host
+		s.vaultBackend.enabled = true
+	}
+}
+
+// WithVaultFromEnvironment configures the Vault backend from the environment variables honored by the Vault CLI
+// (VAULT_ADDR, VAULT_AGENT_ADDR, VAULT_TOKEN, VAULT_CACERT, VAULT_CLIENT_CERT, VAULT_CLIENT_KEY, VAULT_TLS_SERVER_NAME, VAULT_SKIP_VERIFY,
+// VAULT_NAMESPACE and VAULT_CLIENT_TIMEOUT) and the token stored by "vault login" in ~/.vault-token. Any setting supplied
+// explicitly with another option takes precedence, regardless of option order. If a token is found and no authentication
+// type was set, token authentication is used; otherwise if VAULT_AGENT_ADDR is set, AgentVaultAuth is used.
+// This enables the Vault backend on its own, or may be combined with WithVaultBackend.
+func WithVaultFromEnvironment() SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.fromEnvironment = true
+	}
+}
+
+// WithVaultAddresses sets the addresses of several Vault clusters (such as primary and disaster recovery clusters), in
+// order of preference, replacing the host passed to WithVaultBackend (which may then be empty). The most preferred healthy
+// cluster is used: if a request fails with a transient error, the client fails over to the next healthy cluster and logs
+// in again if the token isn't valid there, and it periodically checks whether a more preferred cluster is healthy again.
+func WithVaultAddresses(addrs ...string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.hosts = addrs
+	}
+}
+
+// WithVaultTimeout sets the timeout for requests to Vault (default: none)
+func WithVaultTimeout(timeout time.Duration) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.timeout = timeout
 	}
 }
 
@@ -142,6 +450,43 @@ func WithVaultAuthRetryDelay(secs uint) SecretsClientOption {
 	}
 }
 
+// WithVaultAuthRetryInterval sets the delay between authentication attempts, overriding WithVaultAuthRetryDelay for
+// delays that aren't whole seconds
+func WithVaultAuthRetryInterval(delay time.Duration) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.authRetryDelay = delay
+	}
+}
+
+// WithVaultRetryPolicy sets the retry policy for requests to Vault other than authentication (default: no retries).
+// Unless the policy sets a classifier, connection failures, timeouts and 412, 429 and 5xx responses are retried. Only
+// requests without side effects, such as reading static secrets and Transit decryption, are retried: reading dynamic
+// secrets (GetDynamic), wrapped reads (GetWrapped) and issuing PKI certificates are not. See DefaultRetryPolicy.
+func WithVaultRetryPolicy(policy RetryPolicy) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.retryPolicy = policy
+	}
+}
+
+// WithVaultCircuitBreaker enables a circuit breaker for requests to Vault other than authentication: after threshold
+// consecutive requests fail with transient errors (after any retries), requests fail immediately with ErrCircuitOpen
+// until cooldown has elapsed. A single request is then attempted, which closes the breaker if it succeeds.
+func WithVaultCircuitBreaker(threshold int, cooldown time.Duration) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.breakerThreshold = threshold
+		s.vaultBackend.breakerCooldown = cooldown
+	}
+}
+
 // WithVaultToken sets the token to use when using token auth
 func WithVaultToken(token string) SecretsClientOption {
 	return func(s *secretsClientConfig) {
@@ -163,6 +508,21 @@ func WithVaultK8sAuth(jwt, role string) SecretsClientOption {
 	}
 }
 
+// WithVaultK8sAuthTokenFile sets the file containing the Kubernetes service account JWT and the role to use for
+// authentication (path defaults to DefaultK8sServiceAccountTokenFile if empty). The file is read on every login attempt,
+// so rotated projected service account tokens are used when logging in again. This is also the default for K8sVaultAuth
+// if no JWT is supplied with WithVaultK8sAuth.
+func WithVaultK8sAuthTokenFile(path, role string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.k8sjwt = ""
+		s.vaultBackend.k8sjwtfile = path
+		s.vaultBackend.roleid = role
+	}
+}
+
 // WithVaultK8sAuthPath sets the path for the k8s Vault auth backend (defaults to "kubernetes" otherwise)
 func WithVaultK8sAuthPath(path string) SecretsClientOption {
 	return func(s *secretsClientConfig) {
@@ -173,6 +533,154 @@ func WithVaultK8sAuthPath(path string) SecretsClientOption {
 	}
 }
 
+// WithVaultJWTAuth sets the JWT (eg, an OIDC token issued to a CI job) and role to use for JWT authentication
+func WithVaultJWTAuth(jwt, role string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.jwt = jwt
+		s.vaultBackend.roleid = role
+	}
+}
+
+// WithVaultJWTAuthPath sets the path for the JWT/OIDC Vault auth backend (defaults to "jwt" otherwise)
+func WithVaultJWTAuthPath(path string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.jwtauthpath = path
+	}
+}
+
+// WithVaultUserpassAuth sets the username and password to use for userpass authentication
+func WithVaultUserpassAuth(username, password string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.username = username
+		s.vaultBackend.password = password
+	}
+}
+
+// WithVaultUserpassAuthPath sets the path for the userpass Vault auth backend (defaults to "userpass" otherwise)
+func WithVaultUserpassAuthPath(path string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.userpassauthpath = path
+	}
+}
+
+// WithVaultLDAPAuth sets the username and password to use for LDAP authentication
+func WithVaultLDAPAuth(username, password string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.username = username
+		s.vaultBackend.password = password
+	}
+}
+
+// WithVaultLDAPAuthPath sets the path for the LDAP Vault auth backend (defaults to "ldap" otherwise)
+func WithVaultLDAPAuthPath(path string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.ldapauthpath = path
+	}
+}
+
+// WithVaultCertAuth sets the certificate role to use for TLS certificate authentication. The role is optional; if empty,
+// Vault tries all roles matching the certificate. The client certificate is supplied with WithVaultTLS.
+func WithVaultCertAuth(role string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.roleid = role
+	}
+}
+
+// WithVaultCertAuthPath sets the path for the TLS certificate Vault auth backend (defaults to "cert" otherwise)
+func WithVaultCertAuthPath(path string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.certauthpath = path
+	}
+}
+
+// WithVaultAWSIAMAuth sets the role to use for AWS IAM authentication. Credentials are taken from the standard AWS
+// environment variables or the EC2 instance profile.
+func WithVaultAWSIAMAuth(role string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.roleid = role
+	}
+}
+
+// WithVaultAWSAuthPath sets the path for the AWS Vault auth backend (defaults to "aws" otherwise)
+func WithVaultAWSAuthPath(path string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.awsauthpath = path
+	}
+}
+
+// WithVaultAWSIAMServerID sets the X-Vault-AWS-IAM-Server-ID header value required by the AWS auth backend, if configured
+func WithVaultAWSIAMServerID(id string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.awsserverid = id
+	}
+}
+
+// WithVaultAWSSTSEndpoint sets the STS endpoint and region the sts:GetCallerIdentity request is signed for (defaults to
+// DefaultVaultAWSSTSEndpoint and DefaultVaultAWSSTSRegion otherwise). It must match the endpoint configured in Vault.
+func WithVaultAWSSTSEndpoint(endpoint, region string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.awsstsendpoint = endpoint
+		s.vaultBackend.awsstsregion = region
+	}
+}
+
+// WithVaultGCPAuth sets the role and role type to use for GCP authentication as the GCE instance service account
+func WithVaultGCPAuth(role string, roleType GCPVaultRole) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.roleid = role
+		s.vaultBackend.gcproletype = roleType
+	}
+}
+
+// WithVaultGCPAuthPath sets the path for the GCP Vault auth backend (defaults to "gcp" otherwise)
+func WithVaultGCPAuthPath(path string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.gcpauthpath = path
+	}
+}
+
 // WithVaultRoleID sets the RoleID when using AppRole authentication
 func WithVaultRoleID(roleid string) SecretsClientOption {
 	return func(s *secretsClientConfig) {
@@ -183,6 +691,59 @@ func WithVaultRoleID(roleid string) SecretsClientOption {
 	}
 }
 
+// WithVaultAppRoleSecretID sets the SecretID when using AppRole authentication (optional if the role doesn't require one)
+func WithVaultAppRoleSecretID(secretid string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.secretid = secretid
+	}
+}
+
+// WithVaultAppRoleAuthPath sets the path for the AppRole Vault auth backend (defaults to "approle" otherwise)
+func WithVaultAppRoleAuthPath(path string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.approleauthpath = path
+	}
+}
+
+// WithVaultWrappingToken sets a response-wrapping token that is unwrapped before authenticating, to obtain the Vault
+// token (with TokenVaultAuth) or the AppRole SecretID (with AppRoleVaultAuth). Wrapping tokens are single-use.
+func WithVaultWrappingToken(token string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.wrappingtoken = token
+	}
+}
+
+// WithVaultTLS sets the TLS configuration used to connect to Vault, such as a private CA bundle or a client certificate.
+// May be supplied multiple times; the last one wins.
+func WithVaultTLS(tlsConfig VaultTLSConfig) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.tls = &tlsConfig
+	}
+}
+
+// WithVaultNamespace sets the Vault Enterprise namespace (X-Vault-Namespace) used for authentication and reads.
+// Individual secrets may override it in the mapping with VaultNamespaceSeparator.
+func WithVaultNamespace(namespace string) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.vaultBackend == nil {
+			s.vaultBackend = &vaultBackend{}
+		}
+		s.vaultBackend.namespace = namespace
+	}
+}
+
 func WithVaultValueKey(key string) SecretsClientOption {
 	return func(s *secretsClientConfig) {
 		if s.vaultBackend == nil {
@@ -213,6 +774,23 @@ func WithJSONFileBackend(path string) SecretsClientOption {
 	}
 }
 
+// WithFilePermissionCheck sets how the JSON file and file tree backends treat secret files that are readable or writable by
+// group or others, or not owned by the current user, similar to how ssh treats private keys (default: FilePermissionsIgnore).
+// With FilePermissionsStrict, such files are refused with a *FilePermissionError. Permissions are only checked on Unix.
+func WithFilePermissionCheck(check FilePermissionCheck) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.permissionCheck = check
+	}
+}
+
+// WithMaxSecretSize sets the maximum size in bytes of secret values for all backends (default: MaxFileTreeFileSizeBytes,
+// 2 MB). Larger values return an error wrapping ErrSecretTooLarge.
+func WithMaxSecretSize(bytes int64) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.maxSecretSize = bytes
+	}
+}
+
 // NewSecretsClient returns a SecretsClient configured according to the SecretsClientOptions supplied. Exactly one backend must be enabled.
 // Weird things will happen if you mix options with incompatible backends.
 func NewSecretsClient(ops ...SecretsClientOption) (*SecretsClient, error) {
@@ -220,10 +798,33 @@ func NewSecretsClient(ops ...SecretsClientOption) (*SecretsClient, error) {
 	for _, op := range ops {
 		op(config)
 	}
+	if vb := config.vaultBackend; vb != nil && vb.fromEnvironment {
+		if !vb.enabled {
+			config.betype = vaultBackendType
+			config.backendCount++
+		}
+		if err := vb.applyEnvironment(); err != nil {
+			return nil, fmt.Errorf("error reading Vault configuration from environment: %v", err)
+		}
+	}
 	if config.betype == unknownBackendType || config.backendCount != 1 {
 		return nil, fmt.Errorf("exactly one backend must be enabled")
 	}
-	sc := SecretsClient{}
+	sc := SecretsClient{
+		maxSize:      config.maxSecretSize,
+		transforms:   config.transforms,
+		idTransforms: config.idTransforms,
+		logger:       withLogAttrs(config.logger, "backend", config.betype.name()),
+	}
+	if config.staleIfError {
+		stale, err := newStaleCache(config.maxStale, config.staleCacheFile, config.staleCacheKey, sc.logger)
+		if err != nil {
+			return nil, fmt.Errorf("error loading stale value cache: %w", err)
+		}
+		sc.stale = stale
+	} else if config.staleCacheFile != "" {
+		return nil, fmt.Errorf("WithStaleCacheFile requires WithStaleIfError")
+	}
 	switch config.betype {
 	case vaultBackendType:
 		if config.vaultBackend == nil {
@@ -232,17 +833,34 @@ func NewSecretsClient(ops ...SecretsClientOption) (*SecretsClient, error) {
 		if config.vaultBackend.authentication == UnknownVaultAuth {
 			return nil, fmt.Errorf("vault backend requires an authentication type")
 		}
-		if config.vaultBackend.host == "" {
+		if len(config.vaultBackend.addresses()) == 0 {
 			return nil, fmt.Errorf("vault host is required")
 		}
 		config.vaultBackend.mapping = config.mapping
-		vc, err := getVaultClient(config.vaultBackend)
-		if err != nil {
-			return nil, fmt.Errorf("error creating vault client: %v", err)
+		config.vaultBackend.mappingVars = config.mappingVars
+		config.vaultBackend.logger = sc.logger
+		init := func() (secretBackend, error) {
+			vc, err := getVaultClient(config.vaultBackend)
+			if err != nil {
+				return nil, fmt.Errorf("error creating vault client: %v", err)
+			}
+			vbe, err := newVaultBackendGetter(config.vaultBackend, vc)
+			if err != nil {
+				return nil, fmt.Errorf("error getting vault backend: %v", err)
+			}
+			return vbe, nil
 		}
-		vbe, err := newVaultBackendGetter(config.vaultBackend, vc)
+		vbe, err := init()
 		if err != nil {
-			return nil, fmt.Errorf("error getting vault backend: %v", err)
+			// with cached values from a previous run, start anyway and serve them until Vault is available
+			if sc.stale == nil || sc.stale.empty() {
+				return nil, err
+			}
+			sc.logger.Warn("Vault unavailable, serving cached values until it can be initialized", "error", err)
+			sc.init = init
+			sc.initAttempt = time.Now()
+			sc.initErr = fmt.Errorf("backend unavailable: %w", err)
+			break
 		}
 		sc.backend = vbe
 	case envVarBackendType:
@@ -250,6 +868,7 @@ func NewSecretsClient(ops ...SecretsClientOption) (*SecretsClient, error) {
 			config.envVarBackend = &envVarBackend{}
 		}
 		config.envVarBackend.mapping = config.mapping
+		config.envVarBackend.mappingVars = config.mappingVars
 		ebe, err := newEnvVarBackendGetter(config.envVarBackend)
 		if err != nil {
 			return nil, fmt.Errorf("error getting env var backend: %v", err)
@@ -263,9 +882,12 @@ func NewSecretsClient(ops ...SecretsClientOption) (*SecretsClient, error) {
 			return nil, fmt.Errorf("json file location is required")
 		}
 		config.jsonFileBackend.mapping = config.mapping
+		config.jsonFileBackend.mappingVars = config.mappingVars
+		config.jsonFileBackend.permissionCheck = config.permissionCheck
+		config.jsonFileBackend.logger = sc.logger
 		jbe, err := newjsonFileBackendGetter(config.jsonFileBackend)
 		if err != nil {
-			return nil, fmt.Errorf("error getting JSON file backend: %v", err)
+			return nil, fmt.Errorf("error getting JSON file backend: %w", err)
 		}
 		sc.backend = jbe
 	case fileTreeBackendType:
@@ -279,6 +901,10 @@ func NewSecretsClient(ops ...SecretsClientOption) (*SecretsClient, error) {
 			return nil, fmt.Errorf("filetree root path must be absolute: %v", config.fileTreeBackend.rootPath)
 		}
 		config.fileTreeBackend.mapping = config.mapping
+		config.fileTreeBackend.mappingVars = config.mappingVars
+		config.fileTreeBackend.permissionCheck = config.permissionCheck
+		config.fileTreeBackend.maxSize = config.maxSecretSize
+		config.fileTreeBackend.logger = sc.logger
 		ftg, err := newFileTreeBackendGetter(config.fileTreeBackend)
 		if err != nil {
 			return nil, fmt.Errorf("error getting FileTree backend: %v", err)
@@ -290,33 +916,182 @@ func NewSecretsClient(ops ...SecretsClientOption) (*SecretsClient, error) {
 	return &sc, nil
 }
 
-// SecretMapper maps secrets
+// SecretMapper maps secret IDs to backend locations and back
 type SecretMapper interface {
 	MapSecret(id string) (string, error)
+	UnmapSecret(location string) (string, error)
+}
+
+// ErrMappingNotReversible is returned when a location is unmapped with a mapping that doesn't interpolate {{ .ID }} exactly once, verbatim
+var ErrMappingNotReversible = errors.New("mapping must contain {{ .ID }} exactly once (without functions applied) to derive secret IDs from locations")
+
+// NewSecretMapper returns a SecretMapper for the supplied mapping string, which is interpreted the same way as WithMapping.
+// Only the mapping options (WithMappingEnv, WithMappingService, WithMappingVars) are used from ops.
+// This is useful for tools that need to convert between secret IDs and backend locations without a backend.
+func NewSecretMapper(mapping string, ops ...SecretsClientOption) (SecretMapper, error) {
+	config := &secretsClientConfig{}
+	for _, op := range ops {
+		op(config)
+	}
+	return newSecretMapper(mapping, config.mappingVars)
+}
+
+// MappingFuncs are the functions available in mapping templates:
+//
+//	upper:   {{ upper .ID }}                  uppercases a string
+//	lower:   {{ lower .ID }}                  lowercases a string
+//	replace: {{ replace "/" "_" .ID }}        replaces all occurrences of old with new (pipeline friendly: {{ .ID | replace "/" "_" }})
+//	default: {{ default "dev" .Env }}         returns the first argument if the second is empty (pipeline friendly: {{ .Env | default "dev" }})
+//	env:     {{ env "REGION" }}               returns the value of an environment variable
+var MappingFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+	"env": os.Getenv,
+}
+
+// mappingVars are the values other than the secret ID available to mapping templates
+type mappingVars struct {
+	env     string
+	service string
+	custom  map[string]string
 }
 
+// mappingData is the data supplied when executing a mapping template
+type mappingData struct {
+	ID      string
+	Env     string
+	Service string
+	Vars    map[string]string
+}
+
+// mappingIDSentinel is interpolated as the secret ID to discover the literal text surrounding it in a mapping
+const mappingIDSentinel = "PVCSECRETIDSENTINEL"
+
 // secretMapper manages turning secret IDs into a location suitable for a backend to use
 type secretMapper struct {
 	mappingTmpl *template.Template
+	vars        mappingVars
+	escapeID    func(id string) (string, error) // optional backend-specific policy applied to IDs before interpolation
+	reversible  bool                            // ID appears exactly once, verbatim, in the mapped location
+	prefix      string                          // literal text preceding the ID in every mapped location
+	suffix      string                          // literal text following the ID in every mapped location
 }
 
-// newSecretMapper returns a secret mapper using the supplied mapping string
-func newSecretMapper(mapping string) (*secretMapper, error) {
-	if !strings.Contains(mapping, "{{ .ID") && !strings.Contains(mapping, "{{.ID") {
-		return nil, fmt.Errorf("mapping must contain {{ .ID }}")
-	}
-	tmpl, err := template.New("secret-mapper").Parse(mapping)
+// newSecretMapper returns a secret mapper using the supplied mapping string and template variables
+func newSecretMapper(mapping string, vars mappingVars) (*secretMapper, error) {
+	tmpl, err := template.New("secret-mapper").Funcs(MappingFuncs).Option("missingkey=error").Parse(mapping)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing mapping: %v", err)
 	}
-	return &secretMapper{
+	refs, verbatim := idReferences(tmpl.Tree.Root)
+	if refs == 0 {
+		return nil, fmt.Errorf("mapping must contain {{ .ID }}")
+	}
+	sm := &secretMapper{
 		mappingTmpl: tmpl,
-	}, nil
+		vars:        vars,
+	}
+	loc, err := sm.MapSecret(mappingIDSentinel)
+	if err != nil {
+		return nil, fmt.Errorf("error executing mapping: %v", err)
+	}
+	if refs == 1 && verbatim == 1 && strings.Count(loc, mappingIDSentinel) == 1 {
+		i := strings.Index(loc, mappingIDSentinel)
+		sm.reversible = true
+		sm.prefix = loc[:i]
+		sm.suffix = loc[i+len(mappingIDSentinel):]
+	}
+	return sm, nil
+}
+
+// idReferences walks a parsed mapping template and returns the total number of references to the secret ID, and the number
+// of those that are bare {{ .ID }} actions interpolating the ID verbatim
+func idReferences(node parse.Node) (refs, verbatim int) {
+	isID := func(n parse.Node) bool {
+		switch n := n.(type) {
+		case *parse.FieldNode:
+			return len(n.Ident) > 0 && n.Ident[0] == "ID"
+		case *parse.VariableNode:
+			return len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == "ID"
+		}
+		return false
+	}
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			if p := n.Pipe; len(p.Decl) == 0 && len(p.Cmds) == 1 && len(p.Cmds[0].Args) == 1 && isID(p.Cmds[0].Args[0]) {
+				verbatim++
+			}
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			for _, a := range n.Args {
+				walk(a)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		default:
+			if isID(n) {
+				refs++
+			}
+		}
+	}
+	walk(node)
+	return refs, verbatim
 }
 
-// mapSecret maps a secret ID to a location via the mapping string
+// MapSecret maps a secret ID to a location via the mapping string
 func (sm *secretMapper) MapSecret(id string) (string, error) {
-	d := struct{ ID string }{ID: id}
+	if sm.escapeID != nil {
+		var err error
+		id, err = sm.escapeID(id)
+		if err != nil {
+			return "", fmt.Errorf("invalid secret id: %w", err)
+		}
+	}
+	d := mappingData{
+		ID:      id,
+		Env:     sm.vars.env,
+		Service: sm.vars.service,
+		Vars:    sm.vars.custom,
+	}
 	b := bytes.Buffer{}
 	err := sm.mappingTmpl.Execute(&b, d)
 	if err != nil {
@@ -324,3 +1099,58 @@ func (sm *secretMapper) MapSecret(id string) (string, error) {
 	}
 	return string(b.Bytes()), nil
 }
+
+// UnmapSecret returns the secret ID that maps to location. The mapping must contain {{ .ID }} exactly once, and location
+// must begin and end with the literal text surrounding it.
+func (sm *secretMapper) UnmapSecret(location string) (string, error) {
+	if !sm.reversible {
+		return "", ErrMappingNotReversible
+	}
+	if len(location) <= len(sm.prefix)+len(sm.suffix) ||
+		!strings.HasPrefix(location, sm.prefix) || !strings.HasSuffix(location, sm.suffix) {
+		return "", fmt.Errorf("location does not match mapping: %v", location)
+	}
+	return location[len(sm.prefix) : len(location)-len(sm.suffix)], nil
+}
+
+// listPrefix returns the mapped location prefix shared by all secret IDs beginning with idPrefix
+func (sm *secretMapper) listPrefix(idPrefix string) (string, error) {
+	if !sm.reversible {
+		return "", fmt.Errorf("error listing secrets: %w", ErrMappingNotReversible)
+	}
+	return sm.prefix + idPrefix, nil
+}
+
+// filterIDs sorts ids and removes any that do not begin with prefix
+func filterIDs(ids []string, prefix string) []string {
+	out := []string{}
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// validatePathID ensures a secret ID is a relative slash-separated path that won't be altered by path cleaning:
+// it must not be absolute, contain empty, "." or ".." segments, or contain NUL or other control characters
+func validatePathID(id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("id is empty")
+	}
+	for _, r := range id {
+		if r < 0x20 || r == 0x7f {
+			return "", fmt.Errorf("id contains control character: %q", id)
+		}
+	}
+	for _, seg := range strings.Split(id, "/") {
+		switch seg {
+		case "":
+			return "", fmt.Errorf("id contains empty path segment: %q", id)
+		case ".", "..":
+			return "", fmt.Errorf("id contains relative path segment: %q", id)
+		}
+	}
+	return id, nil
+}
@@ -1,24 +1,77 @@
 package pvc
 
 import (
+	"errors"
+	"io"
+	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
 )
 
-type fakeVaultIO struct{}
+type fakeVaultIO struct {
+	keys map[string][]string
+}
 
 func (fv *fakeVaultIO) TokenAuth(token string) error {
 	return nil
 }
-func (fv *fakeVaultIO) AppRoleAuth(roleid string) error {
+func (fv *fakeVaultIO) AppRoleAuth(roleid, secretid string) error {
 	return nil
 }
 func (fv *fakeVaultIO) K8sAuth(jwt, roleid string) error {
 	return nil
 }
+func (fv *fakeVaultIO) JWTAuth(jwt, role string) error {
+	return nil
+}
+func (fv *fakeVaultIO) UserpassAuth(username, password string) error {
+	return nil
+}
+func (fv *fakeVaultIO) LDAPAuth(username, password string) error {
+	return nil
+}
+func (fv *fakeVaultIO) CertAuth(role string) error {
+	return nil
+}
+func (fv *fakeVaultIO) AWSAuth(role string) error {
+	return nil
+}
+func (fv *fakeVaultIO) GCPAuth(role string) error {
+	return nil
+}
 func (fv *fakeVaultIO) GetValue(path string) ([]byte, error) {
 	return nil, nil
 }
+func (fv *fakeVaultIO) ListKeys(path string) ([]string, error) {
+	return fv.keys[path], nil
+}
+func (fv *fakeVaultIO) ReadSecret(path string) (*api.Secret, error) {
+	return &api.Secret{}, nil
+}
+func (fv *fakeVaultIO) ReadDynamicSecret(path string) (*api.Secret, error) {
+	return &api.Secret{}, nil
+}
+func (fv *fakeVaultIO) RenewLease(path, leaseID string, increment time.Duration) (*api.Secret, error) {
+	return &api.Secret{}, nil
+}
+func (fv *fakeVaultIO) RevokeLease(path, leaseID string) error {
+	return nil
+}
+func (fv *fakeVaultIO) TransitDecrypt(mount, key, ciphertext string) ([]byte, error) {
+	return nil, nil
+}
+func (fv *fakeVaultIO) WriteSecret(path string, data map[string]interface{}) (*api.Secret, error) {
+	return &api.Secret{}, nil
+}
+func (fv *fakeVaultIO) Unwrap(token string) (*api.Secret, error) {
+	return &api.Secret{}, nil
+}
+func (fv *fakeVaultIO) ReadWrapped(path string, ttl time.Duration) (*api.SecretWrapInfo, error) {
+	return &api.SecretWrapInfo{}, nil
+}
 
 func newFakeVaultClient(_ *vaultBackend) (vaultIO, error) {
 	return &fakeVaultIO{}, nil
@@ -196,7 +249,7 @@ func TestNewSecretsClientInvalidBackend(t *testing.T) {
 }
 
 func TestNewSecretMapper(t *testing.T) {
-	sc, err := newSecretMapper("foo/{{ .ID }}/bar")
+	sc, err := newSecretMapper("foo/{{ .ID }}/bar", mappingVars{})
 	if err != nil {
 		t.Fatalf("error getting secret mapper")
 	}
@@ -210,15 +263,277 @@ func TestNewSecretMapper(t *testing.T) {
 }
 
 func TestNewSecretMapperMissingID(t *testing.T) {
-	_, err := newSecretMapper("{{ .Foo }}")
+	_, err := newSecretMapper("{{ .Foo }}", mappingVars{})
 	if err == nil {
 		t.Fatalf("should have failed")
 	}
 }
 
 func TestNewSecretMapperInvalidTemplate(t *testing.T) {
-	_, err := newSecretMapper("{{ .%#$")
+	_, err := newSecretMapper("{{ .%#$", mappingVars{})
 	if err == nil {
 		t.Fatalf("should have failed")
 	}
 }
+
+func TestSecretMapperUnmapSecret(t *testing.T) {
+	sm, err := NewSecretMapper("SECRET_MYAPP_{{ .ID }}_VALUE")
+	if err != nil {
+		t.Fatalf("error getting secret mapper: %v", err)
+	}
+	id, err := sm.UnmapSecret("SECRET_MYAPP_DB_PASSWORD_VALUE")
+	if err != nil {
+		t.Fatalf("error unmapping: %v", err)
+	}
+	if id != "DB_PASSWORD" {
+		t.Fatalf("incorrect id: %v", id)
+	}
+	for _, loc := range []string{"SECRET_MYAPP_DB_PASSWORD", "DB_PASSWORD_VALUE", "SECRET_MYAPP__VALUE", "SECRET_MYAPP_VALUE"} {
+		if _, err := sm.UnmapSecret(loc); err == nil {
+			t.Fatalf("should not have unmapped: %v", loc)
+		}
+	}
+}
+
+func TestSecretMapperUnmapSecretNotReversible(t *testing.T) {
+	sm, err := NewSecretMapper("{{ .ID }}/{{ .ID }}")
+	if err != nil {
+		t.Fatalf("error getting secret mapper: %v", err)
+	}
+	_, err = sm.UnmapSecret("foo/foo")
+	if !errors.Is(err, ErrMappingNotReversible) {
+		t.Fatalf("expected ErrMappingNotReversible, received: %v", err)
+	}
+}
+
+func TestSecretMapperRoundTrip(t *testing.T) {
+	sm, err := NewSecretMapper("secret/{{ .ID }}.txt")
+	if err != nil {
+		t.Fatalf("error getting secret mapper: %v", err)
+	}
+	for _, id := range []string{"foo", "foo/bar", "foo.txt"} {
+		loc, err := sm.MapSecret(id)
+		if err != nil {
+			t.Fatalf("error mapping: %v", err)
+		}
+		got, err := sm.UnmapSecret(loc)
+		if err != nil {
+			t.Fatalf("error unmapping: %v", err)
+		}
+		if got != id {
+			t.Fatalf("round trip failed: %v (expected %v)", got, id)
+		}
+	}
+}
+
+func TestSecretMapperListPrefixNotReversible(t *testing.T) {
+	sm, err := newSecretMapper("{{ .ID }}/{{ .ID }}", mappingVars{})
+	if err != nil {
+		t.Fatalf("error getting secret mapper: %v", err)
+	}
+	if _, err := sm.listPrefix(""); err == nil {
+		t.Fatalf("should have failed")
+	}
+}
+
+func TestSecretMapperTemplateVars(t *testing.T) {
+	if err := os.Setenv("PVC_TEST_REGION", "us-west-2"); err != nil {
+		t.Fatalf("error setting env var: %v", err)
+	}
+	defer os.Unsetenv("PVC_TEST_REGION")
+	tests := []struct {
+		name    string
+		mapping string
+		ops     []SecretsClientOption
+		want    string
+	}{
+		{
+			name:    "env and service",
+			mapping: "secret/{{ .Env }}/{{ .Service }}/{{ .ID }}",
+			ops:     []SecretsClientOption{WithMappingEnv("production"), WithMappingService("billing")},
+			want:    "secret/production/billing/db/password",
+		},
+		{
+			name:    "custom vars",
+			mapping: "secret/{{ .Vars.team }}/{{ .Vars.region }}/{{ .ID }}",
+			ops: []SecretsClientOption{
+				WithMappingVars(map[string]string{"team": "payments", "region": "eu"}),
+				WithMappingVars(map[string]string{"region": "us"}),
+			},
+			want: "secret/payments/us/db/password",
+		},
+		{
+			name:    "upper and replace",
+			mapping: "SECRET_{{ .Service | upper }}_{{ .ID | replace \"/\" \"_\" | upper }}",
+			ops:     []SecretsClientOption{WithMappingService("billing")},
+			want:    "SECRET_BILLING_DB_PASSWORD",
+		},
+		{
+			name:    "lower and default",
+			mapping: "secret/{{ .Env | default \"development\" }}/{{ lower .ID }}",
+			want:    "secret/development/db/password",
+		},
+		{
+			name:    "env lookup",
+			mapping: "secret/{{ env \"PVC_TEST_REGION\" }}/{{ .ID }}",
+			want:    "secret/us-west-2/db/password",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := NewSecretMapper(tt.mapping, tt.ops...)
+			if err != nil {
+				t.Fatalf("error getting secret mapper: %v", err)
+			}
+			v, err := sm.MapSecret("db/password")
+			if err != nil {
+				t.Fatalf("error mapping: %v", err)
+			}
+			if v != tt.want {
+				t.Fatalf("incorrect value: %v (expected %v)", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSecretMapperValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		mapping    string
+		wantErr    bool
+		reversible bool
+	}{
+		{name: "bare", mapping: "{{.ID}}", reversible: true},
+		{name: "root variable", mapping: "foo/{{ $.ID }}", reversible: true},
+		{name: "function applied", mapping: "{{ upper .ID }}"},
+		{name: "pipeline", mapping: "{{ .ID | lower }}"},
+		{name: "conditional", mapping: "{{ if .ID }}foo{{ end }}"},
+		{name: "repeated", mapping: "{{ .ID }}/{{ .ID }}"},
+		{name: "similar field name", mapping: "{{ .IDENTIFIER }}", wantErr: true},
+		{name: "id in text only", mapping: "ID", wantErr: true},
+		{name: "missing var", mapping: "{{ .Vars.missing }}/{{ .ID }}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := newSecretMapper(tt.mapping, mappingVars{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newSecretMapper() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sm.reversible != tt.reversible {
+				t.Fatalf("reversible = %v, expected %v", sm.reversible, tt.reversible)
+			}
+		})
+	}
+}
+
+func TestNewSecretsClientMappingVars(t *testing.T) {
+	if err := os.Setenv("SECRET_PRODUCTION_BILLING_FOO", "bar"); err != nil {
+		t.Fatalf("error setting env var: %v", err)
+	}
+	defer os.Unsetenv("SECRET_PRODUCTION_BILLING_FOO")
+	sc, err := NewSecretsClient(
+		WithEnvVarBackend(),
+		WithMapping("SECRET_{{ .Env }}_{{ .Service }}_{{ .ID }}"),
+		WithMappingEnv("production"),
+		WithMappingService("billing"))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	v, err := sc.Get("foo")
+	if err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+	if string(v) != "bar" {
+		t.Fatalf("bad value: %v", string(v))
+	}
+}
+
+func TestSecretMapperSpecialCharacters(t *testing.T) {
+	sm, err := newSecretMapper("secret/{{ .ID }}", mappingVars{})
+	if err != nil {
+		t.Fatalf("error getting secret mapper: %v", err)
+	}
+	for _, id := range []string{"a&b", "<tag>", "it's", "a+b", `"quoted"`, "100%"} {
+		v, err := sm.MapSecret(id)
+		if err != nil {
+			t.Fatalf("error mapping: %v", err)
+		}
+		if v != "secret/"+id {
+			t.Fatalf("id was altered: %v (expected secret/%v)", v, id)
+		}
+	}
+}
+
+func TestValidatePathID(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{id: "foo"},
+		{id: "foo/bar.txt"},
+		{id: "p&ss+w'rd <1>"},
+		{id: "..foo/bar.."},
+		{id: "", wantErr: true},
+		{id: "/foo", wantErr: true},
+		{id: "foo/", wantErr: true},
+		{id: "foo//bar", wantErr: true},
+		{id: "./foo", wantErr: true},
+		{id: "foo/../../bar", wantErr: true},
+		{id: "..", wantErr: true},
+		{id: "foo\x00bar", wantErr: true},
+		{id: "foo\nbar", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := validatePathID(tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validatePathID(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSecretsClientMaxSecretSize(t *testing.T) {
+	if err := os.Setenv("SECRET_FOO", "foobar"); err != nil {
+		t.Fatalf("error setting env var: %v", err)
+	}
+	defer os.Unsetenv("SECRET_FOO")
+	sc, err := NewSecretsClient(WithEnvVarBackend(), WithMaxSecretSize(3))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if _, err := sc.Get("foo"); !errors.Is(err, ErrSecretTooLarge) {
+		t.Fatalf("expected ErrSecretTooLarge, received: %v", err)
+	}
+	if _, err := sc.GetReader("foo"); !errors.Is(err, ErrSecretTooLarge) {
+		t.Fatalf("expected ErrSecretTooLarge, received: %v", err)
+	}
+	sc, err = NewSecretsClient(WithEnvVarBackend())
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	r, err := sc.GetReader("foo")
+	if err != nil {
+		t.Fatalf("get reader failed: %v", err)
+	}
+	defer r.Close()
+	v, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if string(v) != "foobar" {
+		t.Fatalf("bad value: %v", string(v))
+	}
+}
+
+func TestLimitedReadCloser(t *testing.T) {
+	r := &limitedReadCloser{rc: io.NopCloser(strings.NewReader("foobar")), max: 3}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrSecretTooLarge) {
+		t.Fatalf("expected ErrSecretTooLarge, received: %v", err)
+	}
+	r = &limitedReadCloser{rc: io.NopCloser(strings.NewReader("foobar")), max: 6}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package pvc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the backend while the circuit breaker is open (see WithVaultCircuitBreaker)
+var ErrCircuitOpen = errors.New("circuit breaker open: backend unavailable")
+
+// RetryPolicy configures retries of failed reads with exponential backoff. The zero value disables retries.
+type RetryPolicy struct {
+	MaxRetries   int                  // maximum number of retries after the first attempt
+	InitialDelay time.Duration        // delay before the first retry
+	MaxDelay     time.Duration        // maximum delay between retries (default: no maximum)
+	Multiplier   float64              // factor by which the delay grows after each retry (default: 2)
+	Jitter       float64              // fraction of each delay that is randomized, from 0 (none) to 1
+	Retryable    func(err error) bool // reports whether an error is transient (default: backend-specific classification)
+}
+
+// DefaultRetryPolicy retries transient errors for up to about three seconds, which covers a typical Vault leader election
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:   5,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// delay returns the delay before retry n (starting at 0)
+func (p RetryPolicy) delay(n int) time.Duration {
+	m := p.Multiplier
+	if m == 0 {
+		m = 2
+	}
+	d := float64(p.InitialDelay) * math.Pow(m, float64(n))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// do calls f until it succeeds, returns an error that isn't retryable according to the policy (or retryable if the policy
+// doesn't set a classifier), or the retries are exhausted
+func (p RetryPolicy) do(retryable func(error) bool, f func() error) error {
+	if p.Retryable != nil {
+		retryable = p.Retryable
+	}
+	var err error
+	for i := 0; ; i++ {
+		err = f()
+		if err == nil || i >= p.MaxRetries || !retryable(err) {
+			break
+		}
+		time.Sleep(p.delay(i))
+	}
+	if err != nil && p.MaxRetries > 0 && retryable(err) {
+		return fmt.Errorf("%w (retries exceeded)", err)
+	}
+	return err
+}
+
+// circuitBreaker fails fast after a number of consecutive transient failures, until a cooldown has elapsed. A single
+// trial request is then allowed: if it succeeds the breaker closes, otherwise it opens for another cooldown.
+type circuitBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool // a trial request is in progress
+}
+
+// allow reports whether a request may be attempted
+func (cb *circuitBreaker) allow() bool {
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if time.Now().Before(cb.openUntil) || cb.trial {
+		return false
+	}
+	cb.trial = true
+	return true
+}
+
+// record records the outcome of an allowed request
+func (cb *circuitBreaker) record(failed bool) {
+	cb.Lock()
+	defer cb.Unlock()
+	cb.trial = false
+	if !failed {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openUntil = time.Now().Add(cb.cooldown)
+	}
+}
+
+// do calls f unless the breaker is open, counting errors for which transient returns true as failures
+func (cb *circuitBreaker) do(transient func(error) bool, f func() error) error {
+	if cb == nil {
+		return f()
+	}
+	if !cb.allow() {
+		return ErrCircuitOpen
+	}
+	err := f()
+	cb.record(err != nil && transient(err))
+	return err
+}
@@ -0,0 +1,110 @@
+package pvc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if d := p.delay(i); d != w {
+			t.Fatalf("retry %v: bad delay: %v (wanted %v)", i, d, w)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 100*time.Millisecond || d > 300*time.Millisecond {
+			t.Fatalf("jittered delay out of range: %v", d)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 3, InitialDelay: time.Millisecond}
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		wantErr  bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"transient then success", []error{errTransient, errTransient, nil}, 3, false},
+		{"retries exceeded", []error{errTransient, errTransient, errTransient, errTransient, nil}, 4, true},
+		{"permanent", []error{errors.New("permanent"), nil}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := p.do(isTransient, func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if attempts != tt.attempts {
+				t.Fatalf("bad attempts: %v (wanted %v)", attempts, tt.attempts)
+			}
+		})
+	}
+
+	// a custom classifier overrides the default
+	p.Retryable = func(error) bool { return false }
+	attempts := 0
+	p.do(isTransient, func() error {
+		attempts++
+		return errTransient
+	})
+	if attempts != 1 {
+		t.Fatalf("bad attempts with custom classifier: %v", attempts)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &circuitBreaker{threshold: 2, cooldown: 50 * time.Millisecond}
+	calls := 0
+	fail := func() error {
+		calls++
+		return errTransient
+	}
+	succeed := func() error {
+		calls++
+		return nil
+	}
+	// permanent errors don't count
+	cb.do(isTransient, func() error { return errors.New("not found") })
+	cb.do(isTransient, fail)
+	cb.do(isTransient, succeed)
+	cb.do(isTransient, fail)
+	if err := cb.do(isTransient, fail); !errors.Is(err, errTransient) {
+		t.Fatalf("breaker should still be closed: %v", err)
+	}
+	calls = 0
+	if err := cb.do(isTransient, succeed); !errors.Is(err, ErrCircuitOpen) || calls != 0 {
+		t.Fatalf("breaker should be open: %v (calls: %v)", err, calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	// the trial request fails and the breaker opens again
+	if err := cb.do(isTransient, fail); !errors.Is(err, errTransient) || calls != 1 {
+		t.Fatalf("trial request should be attempted: %v (calls: %v)", err, calls)
+	}
+	if err := cb.do(isTransient, succeed); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("breaker should be open after failed trial: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := cb.do(isTransient, succeed); err != nil {
+		t.Fatalf("trial request should succeed: %v", err)
+	}
+	if err := cb.do(isTransient, succeed); err != nil {
+		t.Fatalf("breaker should be closed: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package pvc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Secret is a secret value returned by Lookup
+type Secret struct {
+	Value     []byte
+	FetchedAt time.Time // when the value was read from the backend
+	Stale     bool      // the backend failed and Value is the last value read successfully
+	Err       error     // if Stale, the backend error
+}
+
+// WithStaleIfError enables serving the last value read successfully for a secret when the backend is unavailable, such
+// as during Vault maintenance (connection failures, timeouts, 412, 429 and 5xx responses, or an open circuit breaker).
+// Values are kept in memory (and on disk with WithStaleCacheFile) and are served for at most maxStale after they were
+// read (zero means no limit). Lookup reports whether a value is stale.
+func WithStaleIfError(maxStale time.Duration) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.staleIfError = true
+		s.maxStale = maxStale
+	}
+}
+
+// WithStaleCacheFile persists the values kept by WithStaleIfError to a file encrypted with AES-GCM using key (16, 24 or
+// 32 bytes), so that they are available after a restart. If the Vault backend cannot be initialized when the client is
+// created but the file contains values, the client is created anyway and initialization is retried as secrets are read.
+func WithStaleCacheFile(path string, key []byte) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.staleCacheFile = path
+		s.staleCacheKey = key
+	}
+}
+
+// Lookup returns a secret from the configured backend, after applying any transformers. With WithStaleIfError, if the
+// backend is unavailable and a previous value is cached, the cached value is returned with Stale set. Other errors, such
+// as the secret not being found or access being denied, are always returned.
+func (sc *SecretsClient) Lookup(id string) (*Secret, error) {
+	var v []byte
+	be, err := sc.getBackend()
+	unavailable := err != nil // the backend could not be initialized
+	if err == nil {
+		v, err = be.Get(id)
+		unavailable = err != nil && unavailableError(err)
+	}
+	if err != nil {
+		if sc.stale == nil || !unavailable {
+			return nil, err
+		}
+		e, ok := sc.stale.get(id)
+		if !ok {
+			return nil, err
+		}
+		out, perr := sc.process(id, e.Value)
+		if perr != nil {
+			return nil, perr
+		}
+		loggerOrDefault(sc.logger).Warn("error getting secret, using cached value",
+			"id", id, "fetched_at", e.FetchedAt, "error", err)
+		return &Secret{Value: out, FetchedAt: e.FetchedAt, Stale: true, Err: err}, nil
+	}
+	out, err := sc.process(id, v)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if sc.stale != nil {
+		sc.stale.put(id, v, now)
+	}
+	return &Secret{Value: out, FetchedAt: now}, nil
+}
+
+// unavailableError reports whether err means that the backend is unavailable, such as a connection failure, a 5xx
+// response or an open circuit breaker, as opposed to the secret not being readable (eg, it doesn't exist or access is
+// denied), which a stale value must not hide
+func unavailableError(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || retryableVaultError(err)
+}
+
+// staleCacheWriteInterval is the minimum interval between writes of the cache file when values are unchanged
+var staleCacheWriteInterval = time.Minute
+
+// staleCacheAD is the additional authenticated data for the cache file, which versions the format
+var staleCacheAD = []byte("pvc stale cache v1")
+
+type staleEntry struct {
+	Value     []byte    `json:"value"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// staleCache holds the last raw backend value read successfully for each secret
+type staleCache struct {
+	mu       sync.Mutex
+	maxStale time.Duration
+	entries  map[string]staleEntry
+	path     string
+	aead     cipher.AEAD
+	written  time.Time
+	logger   Logger
+}
+
+func newStaleCache(maxStale time.Duration, path string, key []byte, logger Logger) (*staleCache, error) {
+	c := &staleCache{
+		maxStale: maxStale,
+		entries:  map[string]staleEntry{},
+		path:     path,
+		logger:   loggerOrDefault(logger),
+	}
+	if path == "" {
+		return c, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache key: %v", err)
+	}
+	c.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %v", err)
+	}
+	if err := c.load(); err != nil {
+		// an unreadable cache only matters during an outage, so don't prevent the client from being created
+		c.logger.Warn("ignoring stale value cache file", "path", path, "error", err)
+	}
+	return c, nil
+}
+
+// empty returns whether no values are cached within the maximum staleness
+func (c *staleCache) empty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if c.fresh(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *staleCache) fresh(e staleEntry) bool {
+	return c.maxStale == 0 || time.Since(e.FetchedAt) <= c.maxStale
+}
+
+func (c *staleCache) get(id string) (staleEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok || !c.fresh(e) {
+		return staleEntry{}, false
+	}
+	return e, true
+}
+
+func (c *staleCache) put(id string, value []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, ok := c.entries[id]
+	c.entries[id] = staleEntry{Value: append([]byte(nil), value...), FetchedAt: now}
+	if c.path == "" || (ok && string(prev.Value) == string(value) && now.Sub(c.written) < staleCacheWriteInterval) {
+		return
+	}
+	if err := c.save(); err != nil {
+		c.logger.Warn("error writing stale value cache file", "path", c.path, "error", err)
+		return
+	}
+	c.written = now
+}
+
+// load reads the cache file, which is the nonce followed by the sealed JSON-encoded entries
+func (c *staleCache) load() error {
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	ns := c.aead.NonceSize()
+	if len(b) < ns {
+		return fmt.Errorf("file too short")
+	}
+	pt, err := c.aead.Open(nil, b[:ns], b[ns:], staleCacheAD)
+	if err != nil {
+		return fmt.Errorf("error decrypting: %v", err)
+	}
+	entries := map[string]staleEntry{}
+	if err := json.Unmarshal(pt, &entries); err != nil {
+		return fmt.Errorf("error decoding: %v", err)
+	}
+	c.entries = entries
+	return nil
+}
+
+// save writes the cache file atomically, readable only by the owner
+func (c *staleCache) save() error {
+	pt, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("error encoding: %v", err)
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("error generating nonce: %v", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(c.aead.Seal(nonce, nonce, pt, staleCacheAD)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
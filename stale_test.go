@@ -0,0 +1,203 @@
+package pvc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestSecretsClientLookupStale(t *testing.T) {
+	var down bool
+	fb := &fakeBackend{
+		GetFunc: func(id string) ([]byte, error) {
+			if down {
+				return nil, fmt.Errorf("error reading value: %w", &api.ResponseError{StatusCode: http.StatusServiceUnavailable})
+			}
+			return []byte(" value "), nil
+		},
+	}
+	stale, err := newStaleCache(time.Hour, "", nil, nil)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	sc := &SecretsClient{backend: fb, stale: stale, transforms: []Transformer{TrimSpace}}
+	s, err := sc.Lookup("foo")
+	if err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+	if s.Stale || string(s.Value) != "value" {
+		t.Fatalf("unexpected secret: %+v", s)
+	}
+	down = true
+	s, err = sc.Lookup("foo")
+	if err != nil {
+		t.Fatalf("error getting stale secret: %v", err)
+	}
+	if !s.Stale || s.Err == nil || string(s.Value) != "value" {
+		t.Fatalf("unexpected stale secret: %+v", s)
+	}
+	if _, err := sc.Get("bar"); err == nil {
+		t.Fatalf("expected error for uncached secret")
+	}
+	stale.entries["foo"] = staleEntry{Value: []byte("value"), FetchedAt: time.Now().Add(-2 * time.Hour)}
+	if _, err := sc.Get("foo"); err == nil {
+		t.Fatalf("expected error for value older than the maximum staleness")
+	}
+}
+
+func TestSecretsClientLookupStaleErrors(t *testing.T) {
+	var getErr error
+	fb := &fakeBackend{
+		GetFunc: func(id string) ([]byte, error) {
+			return []byte("value"), getErr
+		},
+	}
+	stale, err := newStaleCache(0, "", nil, nil)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	sc := &SecretsClient{backend: fb, stale: stale}
+	if _, err := sc.Lookup("foo"); err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+	tests := []struct {
+		name  string
+		err   error
+		stale bool
+	}{
+		{"circuit open", ErrCircuitOpen, true},
+		{"connection refused", fmt.Errorf("error reading value: %w", syscall.ECONNREFUSED), true},
+		{"retries exceeded", fmt.Errorf("%w (retries exceeded)", &api.ResponseError{StatusCode: http.StatusBadGateway}), true},
+		{"permission denied", &api.ResponseError{StatusCode: http.StatusForbidden}, false},
+		{"not found", fmt.Errorf("secret not found: secret/foo"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getErr = tt.err
+			s, err := sc.Lookup("foo")
+			if !tt.stale {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected backend error, received: %v (secret: %+v)", err, s)
+				}
+				return
+			}
+			if err != nil || !s.Stale || !errors.Is(s.Err, tt.err) {
+				t.Fatalf("expected stale secret: %+v (error: %v)", s, err)
+			}
+		})
+	}
+}
+
+func TestStaleCacheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	key := bytes.Repeat([]byte{1}, 32)
+	c, err := newStaleCache(0, path, key, nil)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	c.put("foo", []byte("bar"), time.Now())
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("error reading cache file: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("bad cache file mode: %v", fi.Mode())
+	}
+	b, _ := os.ReadFile(path)
+	if bytes.Contains(b, []byte("bar")) {
+		t.Fatalf("cache file contains plaintext value")
+	}
+	c, err = newStaleCache(0, path, key, nil)
+	if err != nil {
+		t.Fatalf("error loading cache: %v", err)
+	}
+	if e, ok := c.get("foo"); !ok || string(e.Value) != "bar" {
+		t.Fatalf("unexpected entry: %+v (found: %v)", e, ok)
+	}
+	c, err = newStaleCache(0, path, bytes.Repeat([]byte{2}, 32), nil)
+	if err != nil {
+		t.Fatalf("error creating cache with wrong key: %v", err)
+	}
+	if !c.empty() {
+		t.Fatalf("expected cache with wrong key to be empty")
+	}
+	if _, err := newStaleCache(0, path, []byte("short"), nil); err == nil {
+		t.Fatalf("expected error for invalid key")
+	}
+}
+
+func TestNewSecretsClientStaleCacheFileRequiresStaleIfError(t *testing.T) {
+	_, err := NewSecretsClient(
+		WithEnvVarBackend(),
+		WithStaleCacheFile(filepath.Join(t.TempDir(), "cache"), bytes.Repeat([]byte{1}, 16)),
+	)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestNewSecretsClientVaultUnavailableStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	key := bytes.Repeat([]byte{1}, 32)
+	c, err := newStaleCache(0, path, key, nil)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	c.put("foo", []byte("cached"), time.Now())
+
+	unavailable := true
+	getVaultClient = func(vb *vaultBackend) (vaultIO, error) {
+		if unavailable {
+			return nil, errors.New("connection refused")
+		}
+		return &fakeVaultIO{}, nil
+	}
+	defer func() { getVaultClient = newVaultClient }()
+	opts := []SecretsClientOption{
+		WithVaultBackend(TokenVaultAuth, "foo"),
+		WithVaultToken("token"),
+		WithStaleIfError(0),
+		WithStaleCacheFile(path, key),
+	}
+	sc, err := NewSecretsClient(opts...)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	s, err := sc.Lookup("foo")
+	if err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+	if !s.Stale || string(s.Value) != "cached" {
+		t.Fatalf("unexpected secret: %+v", s)
+	}
+
+	interval := backendInitInterval
+	backendInitInterval = 0
+	defer func() { backendInitInterval = interval }()
+	unavailable = false
+	s, err = sc.Lookup("foo")
+	if err != nil {
+		t.Fatalf("error getting secret: %v", err)
+	}
+	if s.Stale {
+		t.Fatalf("expected backend to be initialized")
+	}
+	if _, ok := sc.backend.(*vaultBackendGetter); !ok {
+		t.Fatalf("wrong backend type: %T", sc.backend)
+	}
+
+	// without cached values, an unavailable backend is an error
+	unavailable = true
+	opts[3] = WithStaleCacheFile(filepath.Join(t.TempDir(), "empty"), key)
+	if _, err := NewSecretsClient(opts...); err == nil {
+		t.Fatalf("expected error")
+	}
+}
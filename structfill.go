@@ -13,6 +13,9 @@ var SecretStructTag = "secret"
 // to return an error.
 // Note that Fill doesn't check the secret type; if the field value is a string, the byte slice returned
 // by the backend for that secret will be converted to a string.
+// If the backend supports multi-key secrets (see GetMap), tags may select a key within a secret with
+// FileTreeSelectorSeparator (eg, `secret:"db#username"` and `secret:"db#password"`), and each secret is read only once.
+// Other backends get the whole tag as the secret ID (the file tree backend selects keys within files itself).
 func (sc *SecretsClient) Fill(s interface{}) error {
 	if s == nil {
 		return fmt.Errorf("struct is nil")
@@ -31,6 +34,7 @@ func (sc *SecretsClient) Fill(s interface{}) error {
 		return fmt.Errorf("s must be a pointer to a struct")
 	}
 
+	maps := map[string]map[string]interface{}{} // multi-key secrets already read, by ID
 	for i := 0; i < v.NumField(); i++ {
 		// Get the field tag value
 		tag := v.Type().Field(i).Tag.Get(SecretStructTag)
@@ -48,7 +52,7 @@ func (sc *SecretsClient) Fill(s interface{}) error {
 			return fmt.Errorf("can't set field %v of type %v", fn, fld.Type().String())
 		}
 
-		val, err := sc.Get(tag)
+		val, err := sc.fillValue(tag, maps)
 		if err != nil {
 			return fmt.Errorf("error getting secret: %v: %w", tag, err)
 		}
@@ -70,3 +74,35 @@ func (sc *SecretsClient) Fill(s interface{}) error {
 	}
 	return nil
 }
+
+// fillValue returns the secret value for a Fill tag. Tags selecting a key within a secret are read with GetMap if the
+// backend supports it, reusing secrets in maps that were already read.
+func (sc *SecretsClient) fillValue(tag string, maps map[string]map[string]interface{}) ([]byte, error) {
+	id, key := splitSelector(tag)
+	be, err := sc.getBackend()
+	if err != nil {
+		return sc.Get(tag)
+	}
+	mg, ok := be.(secretMapGetter)
+	if !ok || key == "" {
+		return sc.Get(tag)
+	}
+	m, ok := maps[id]
+	if !ok {
+		var err error
+		m, err = mg.GetMap(id)
+		if err != nil {
+			return nil, err
+		}
+		maps[id] = m
+	}
+	v, ok := m[key]
+	if !ok {
+		return nil, fmt.Errorf("key not found in secret: %v", key)
+	}
+	s, err := stringValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding value of key %v: %v", key, err)
+	}
+	return sc.process(tag, []byte(s))
+}
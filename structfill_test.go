@@ -17,6 +17,10 @@ func (fb *fakeBackend) Get(id string) ([]byte, error) {
 	return []byte{}, nil
 }
 
+func (fb *fakeBackend) List(prefix string) ([]string, error) {
+	return []string{}, nil
+}
+
 var _ secretBackend = &fakeBackend{}
 
 type secrets struct {
@@ -165,3 +169,66 @@ func TestSecretsClient_Fill(t *testing.T) {
 		})
 	}
 }
+
+// fakeMapBackend is a backend storing several keys per secret
+type fakeMapBackend struct {
+	fakeBackend
+	secrets map[string]map[string]interface{}
+	reads   map[string]int
+}
+
+func (fb *fakeMapBackend) GetMap(id string) (map[string]interface{}, error) {
+	fb.reads[id]++
+	m, ok := fb.secrets[id]
+	if !ok {
+		return nil, fmt.Errorf("secret not found: %v", id)
+	}
+	return m, nil
+}
+
+var _ secretMapGetter = &fakeMapBackend{}
+
+func TestSecretsClient_FillMultiKey(t *testing.T) {
+	type dbsecrets struct {
+		Username string `secret:"db#username"`
+		Password []byte `secret:"db#password"`
+		Options  string `secret:"db#options"`
+		Name     string `secret:"name"`
+	}
+	fb := &fakeMapBackend{
+		fakeBackend: fakeBackend{
+			GetFunc: func(id string) ([]byte, error) {
+				if id != "name" {
+					return nil, fmt.Errorf("unexpected Get: %v", id)
+				}
+				return []byte("Frank"), nil
+			},
+		},
+		secrets: map[string]map[string]interface{}{
+			"db": {
+				"username": "app",
+				"password": "pa55w0rd",
+				"options":  map[string]interface{}{"sslmode": "require"},
+			},
+		},
+		reads: map[string]int{},
+	}
+	sc := &SecretsClient{backend: fb}
+	s := dbsecrets{}
+	if err := sc.Fill(&s); err != nil {
+		t.Fatalf("error filling struct: %v", err)
+	}
+	if s.Username != "app" || string(s.Password) != "pa55w0rd" || s.Options != `{"sslmode":"require"}` || s.Name != "Frank" {
+		t.Fatalf("bad values: %+v", s)
+	}
+	if fb.reads["db"] != 1 {
+		t.Fatalf("secret should be read once: %v", fb.reads)
+	}
+
+	missing := struct {
+		Host string `secret:"db#host"`
+	}{}
+	if err := sc.Fill(&missing); err == nil {
+		t.Fatalf("expected error for missing key")
+	}
+}
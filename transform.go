@@ -0,0 +1,165 @@
+package pvc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Transformer transforms a secret value after it is retrieved from the backend
+type Transformer func(value []byte) ([]byte, error)
+
+// WithTransform adds transformers that are applied in order to every secret value retrieved by the client (including by
+// Fill and GetReader). It may be supplied multiple times; transformers are appended.
+func WithTransform(ts ...Transformer) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		s.transforms = append(s.transforms, ts...)
+	}
+}
+
+// WithSecretTransform adds transformers that are applied in order to the value of the secret with the specified ID, after
+// any transformers added with WithTransform
+func WithSecretTransform(id string, ts ...Transformer) SecretsClientOption {
+	return func(s *secretsClientConfig) {
+		if s.idTransforms == nil {
+			s.idTransforms = map[string][]Transformer{}
+		}
+		s.idTransforms[id] = append(s.idTransforms[id], ts...)
+	}
+}
+
+// Chain returns a Transformer that applies ts in order
+func Chain(ts ...Transformer) Transformer {
+	return func(value []byte) ([]byte, error) {
+		var err error
+		for _, t := range ts {
+			value, err = t(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return value, nil
+	}
+}
+
+// TrimSpace removes leading and trailing whitespace (including newlines)
+var TrimSpace Transformer = func(value []byte) ([]byte, error) {
+	return bytes.TrimSpace(value), nil
+}
+
+// TrimNewline removes trailing newlines ("\n" or "\r\n"), such as those added by editors to files in the file tree backend
+var TrimNewline Transformer = func(value []byte) ([]byte, error) {
+	return bytes.TrimRight(value, "\r\n"), nil
+}
+
+// Base64Decode decodes standard base64 (with or without padding), as recommended for storing binary values
+var Base64Decode Transformer = func(value []byte) ([]byte, error) {
+	enc := base64.StdEncoding
+	if !bytes.HasSuffix(value, []byte("=")) && len(value)%4 != 0 {
+		enc = base64.RawStdEncoding
+	}
+	out := make([]byte, enc.DecodedLen(len(value)))
+	n, err := enc.Decode(out, value)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding base64: %v", err)
+	}
+	return out[:n], nil
+}
+
+// HexDecode decodes hexadecimal
+var HexDecode Transformer = func(value []byte) ([]byte, error) {
+	out := make([]byte, hex.DecodedLen(len(value)))
+	n, err := hex.Decode(out, value)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding hex: %v", err)
+	}
+	return out[:n], nil
+}
+
+// Gunzip returns a Transformer that decompresses gzip data. Decompressed values larger than maxBytes return an error
+// wrapping ErrSecretTooLarge, which protects against decompression bombs.
+func Gunzip(maxBytes int64) Transformer {
+	return func(value []byte) ([]byte, error) {
+		zr, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip header: %v", err)
+		}
+		out, err := io.ReadAll(&limitedReadCloser{rc: io.NopCloser(zr), max: maxBytes})
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing gzip: %w", err)
+		}
+		return out, nil
+	}
+}
+
+// JSONField returns a Transformer that selects a field from a JSON object. Nested fields are separated by dots
+// (eg, "data.password"). String values are returned literally, any other type is returned as JSON.
+func JSONField(path string) Transformer {
+	return func(value []byte) ([]byte, error) {
+		raw := json.RawMessage(value)
+		for _, f := range strings.Split(path, ".") {
+			obj := map[string]json.RawMessage{}
+			if err := json.Unmarshal(raw, &obj); err != nil {
+				return nil, fmt.Errorf("error decoding JSON object for field %v: %v", f, err)
+			}
+			v, ok := obj[f]
+			if !ok {
+				return nil, fmt.Errorf("JSON field not found: %v", path)
+			}
+			raw = v
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return []byte(s), nil
+		}
+		return raw, nil
+	}
+}
+
+// PEMBlock returns a Transformer that selects the first PEM block of the specified type (eg, "CERTIFICATE" or
+// "RSA PRIVATE KEY") from a value containing one or more PEM blocks, and returns it PEM-encoded. An empty type selects
+// the first block.
+func PEMBlock(blockType string) Transformer {
+	return func(value []byte) ([]byte, error) {
+		rest := value
+		for {
+			var b *pem.Block
+			b, rest = pem.Decode(rest)
+			if b == nil {
+				return nil, fmt.Errorf("PEM block not found: %q", blockType)
+			}
+			if blockType == "" || b.Type == blockType {
+				return pem.EncodeToMemory(b), nil
+			}
+		}
+	}
+}
+
+// transform applies the client-wide and per-ID transformers to the value of the secret with the specified ID
+func (sc *SecretsClient) transform(id string, value []byte) ([]byte, error) {
+	var err error
+	for _, t := range sc.transforms {
+		value, err = t(value)
+		if err != nil {
+			return nil, fmt.Errorf("error transforming secret %v: %w", id, err)
+		}
+	}
+	for _, t := range sc.idTransforms[id] {
+		value, err = t(value)
+		if err != nil {
+			return nil, fmt.Errorf("error transforming secret %v: %w", id, err)
+		}
+	}
+	return value, nil
+}
+
+// hasTransforms returns whether any transformers apply to the secret with the specified ID
+func (sc *SecretsClient) hasTransforms(id string) bool {
+	return len(sc.transforms) > 0 || len(sc.idTransforms[id]) > 0
+}
@@ -2,8 +2,15 @@ package pvc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/vault/api"
@@ -14,28 +21,94 @@ const (
 	DefaultVaultMapping = "secret/{{ .ID }}"
 )
 
+// DefaultK8sServiceAccountTokenFile is where Kubernetes mounts the pod service account token
+const DefaultK8sServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultNamespaceSeparator separates a Vault Enterprise namespace from the path in a mapped location, which overrides the
+// client namespace (see WithVaultNamespace) for that secret (eg, "engineering/{{ .Service }}::secret/{{ .ID }}").
+// An empty namespace before the separator refers to the root namespace.
+const VaultNamespaceSeparator = "::"
+
 // VaultAuthentication enumerates the supported Vault authentication methods
 type VaultAuthentication int
 
 // Supported Vault authentication methods
 const (
-	UnknownVaultAuth VaultAuthentication = iota // Unknown/unset
-	TokenVaultAuth                              // Token authentication
-	AppRoleVaultAuth                            // AppRole
-	K8sVaultAuth                                // Kubernetes
+	UnknownVaultAuth  VaultAuthentication = iota // Unknown/unset
+	TokenVaultAuth                               // Token authentication
+	AppRoleVaultAuth                             // AppRole
+	K8sVaultAuth                                 // Kubernetes
+	JWTVaultAuth                                 // JWT/OIDC
+	UserpassVaultAuth                            // Username and password
+	LDAPVaultAuth                                // LDAP
+	CertVaultAuth                                // TLS certificate
+	AWSIAMVaultAuth                              // AWS IAM
+	GCPVaultAuth                                 // GCP
+	AgentVaultAuth                               // None: a Vault Agent or Proxy adds its auto-auth token to requests
 )
 
+// VaultTLSConfig configures TLS for connections to Vault. Unset fields use the defaults (system trust store, TLS 1.2).
+type VaultTLSConfig struct {
+	CACertFile         string // path to a PEM-encoded CA certificate bundle used to verify Vault (takes precedence over CACertPEM)
+	CACertPEM          []byte // PEM-encoded CA certificate bundle used to verify Vault
+	ClientCertFile     string // path to a PEM-encoded client certificate (requires ClientKeyFile)
+	ClientKeyFile      string // path to the PEM-encoded private key for ClientCertFile
+	ServerName         string // server name used for SNI and to verify the Vault certificate, if different from the host
+	MinVersion         uint16 // minimum TLS version (eg, tls.VersionTLS13)
+	InsecureSkipVerify bool   // disables verification of the Vault certificate: for development only, never in production
+}
+
+// apply configures TLS for the Vault API client config
+func (t *VaultTLSConfig) apply(config *api.Config) error {
+	err := config.ConfigureTLS(&api.TLSConfig{
+		CACert:        t.CACertFile,
+		CACertBytes:   t.CACertPEM,
+		ClientCert:    t.ClientCertFile,
+		ClientKey:     t.ClientKeyFile,
+		TLSServerName: t.ServerName,
+		Insecure:      t.InsecureSkipVerify,
+	})
+	if err != nil {
+		return err
+	}
+	if t.MinVersion != 0 {
+		transport, ok := config.HttpClient.Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("unexpected HTTP transport type: %T", config.HttpClient.Transport)
+		}
+		transport.TLSClientConfig.MinVersion = t.MinVersion
+	}
+	return nil
+}
+
 type vaultBackendGetter struct {
-	vc     vaultIO
-	mapper SecretMapper
-	config *vaultBackend
+	vc      vaultIO
+	mapper  *secretMapper
+	config  *vaultBackend
+	leaseMu sync.Mutex
+	leases  map[string]*vaultLease // dynamic secret leases being renewed, by lease ID
+	certs   []*pkiCertificate      // PKI certificates being renewed
+}
+
+// validateVaultID validates id as a path, which must not contain VaultNamespaceSeparator so that only the mapping can
+// select a namespace
+func validateVaultID(id string) (string, error) {
+	if strings.Contains(id, VaultNamespaceSeparator) {
+		return "", fmt.Errorf("id contains namespace separator %q: %q", VaultNamespaceSeparator, id)
+	}
+	return validatePathID(id)
 }
 
 func newVaultBackendGetter(vb *vaultBackend, vc vaultIO) (*vaultBackendGetter, error) {
 	var err error
-	if vb.host == "" {
+	if len(vb.addresses()) == 0 {
 		return nil, fmt.Errorf("Vault host is required")
 	}
+	if vb.wrappingtoken != "" {
+		if err := unwrapCredentials(vb, vc); err != nil {
+			return nil, fmt.Errorf("error unwrapping credentials: %v", err)
+		}
+	}
 	switch vb.authentication {
 	case TokenVaultAuth:
 		err = vc.TokenAuth(vb.token)
@@ -43,22 +116,63 @@ func newVaultBackendGetter(vb *vaultBackend, vc vaultIO) (*vaultBackendGetter, e
 			return nil, fmt.Errorf("error authenticating with supplied token: %v", err)
 		}
 	case AppRoleVaultAuth:
-		return nil, fmt.Errorf("AppRole authentication not implemented")
+		err = vc.AppRoleAuth(vb.roleid, vb.secretid)
+		if err != nil {
+			return nil, fmt.Errorf("error performing AppRole authentication: %v", err)
+		}
 	case K8sVaultAuth:
 		err = vc.K8sAuth(vb.k8sjwt, vb.roleid)
 		if err != nil {
 			return nil, fmt.Errorf("error performing Kubernetes authentication: %v", err)
 		}
+	case JWTVaultAuth:
+		err = vc.JWTAuth(vb.jwt, vb.roleid)
+		if err != nil {
+			return nil, fmt.Errorf("error performing JWT authentication: %v", err)
+		}
+	case UserpassVaultAuth:
+		err = vc.UserpassAuth(vb.username, vb.password)
+		if err != nil {
+			return nil, fmt.Errorf("error performing userpass authentication: %v", err)
+		}
+	case LDAPVaultAuth:
+		err = vc.LDAPAuth(vb.username, vb.password)
+		if err != nil {
+			return nil, fmt.Errorf("error performing LDAP authentication: %v", err)
+		}
+	case CertVaultAuth:
+		if vb.tls == nil || vb.tls.ClientCertFile == "" {
+			return nil, fmt.Errorf("TLS certificate authentication requires a client certificate (see WithVaultTLS)")
+		}
+		err = vc.CertAuth(vb.roleid)
+		if err != nil {
+			return nil, fmt.Errorf("error performing TLS certificate authentication: %v", err)
+		}
+	case AWSIAMVaultAuth:
+		err = vc.AWSAuth(vb.roleid)
+		if err != nil {
+			return nil, fmt.Errorf("error performing AWS IAM authentication: %v", err)
+		}
+	case GCPVaultAuth:
+		err = vc.GCPAuth(vb.roleid)
+		if err != nil {
+			return nil, fmt.Errorf("error performing GCP authentication: %v", err)
+		}
+	case AgentVaultAuth:
+		// requests are sent without a token, which the agent adds (use_auto_auth_token)
 	default:
 		return nil, fmt.Errorf("unknown authentication method: %v", vb.authentication)
 	}
 	if vb.mapping == "" {
 		vb.mapping = DefaultVaultMapping
 	}
-	sm, err := newSecretMapper(vb.mapping)
+	sm, err := newSecretMapper(vb.mapping, vb.mappingVars)
 	if err != nil {
 		return nil, fmt.Errorf("error with mapping: %v", err)
 	}
+	// The Vault client URL path-escapes the mapped path on the wire, so IDs are interpolated literally. IDs with
+	// segments that would be collapsed by path cleaning (and so refer to a different secret) are rejected.
+	sm.escapeID = validateVaultID
 	return &vaultBackendGetter{
 		vc:     vc,
 		mapper: sm,
@@ -73,24 +187,99 @@ func (vbg *vaultBackendGetter) Get(id string) ([]byte, error) {
 	}
 	v, err := vbg.vc.GetValue(path)
 	if err != nil {
-		return nil, fmt.Errorf("error reading value: %v", err)
+		return nil, fmt.Errorf("error reading value (id: %v): %w", id, err)
 	}
 	return v, nil
 }
 
+// GetMap reads the secret for id and returns all of its keys
+func (vbg *vaultBackendGetter) GetMap(id string) (map[string]interface{}, error) {
+	path, err := vbg.mapper.MapSecret(id)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping id to path: %v", err)
+	}
+	s, err := vbg.vc.ReadSecret(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret (id: %v): %v", id, err)
+	}
+	return s.Data, nil
+}
+
+// List recursively lists the Vault paths under the mapped prefix and returns the IDs of all leaf secrets
+func (vbg *vaultBackendGetter) List(prefix string) ([]string, error) {
+	lp, err := vbg.mapper.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	dir := ""
+	if i := strings.LastIndex(lp, "/"); i >= 0 {
+		dir = lp[:i+1]
+	}
+	ids := []string{}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		keys, err := vbg.vc.ListKeys(dir)
+		if err != nil {
+			return fmt.Errorf("error listing keys: %w", err)
+		}
+		for _, k := range keys {
+			if strings.HasSuffix(k, "/") {
+				if err := walk(dir + k); err != nil {
+					return err
+				}
+				continue
+			}
+			if id, err := vbg.mapper.UnmapSecret(dir + k); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		return nil
+	}
+	if err := walk(dir); err != nil {
+		return nil, err
+	}
+	return filterIDs(ids, prefix), nil
+}
+
 // vaultIO describes an object capable of interacting with Vault
 type vaultIO interface {
 	TokenAuth(token string) error
-	AppRoleAuth(roleid string) error
+	AppRoleAuth(roleid, secretid string) error
 	K8sAuth(jwt, roleid string) error
+	JWTAuth(jwt, role string) error
+	UserpassAuth(username, password string) error
+	LDAPAuth(username, password string) error
+	CertAuth(role string) error
+	AWSAuth(role string) error
+	GCPAuth(role string) error
 	GetValue(path string) ([]byte, error)
+	ListKeys(path string) ([]string, error)
+	ReadSecret(path string) (*api.Secret, error)
+	ReadDynamicSecret(path string) (*api.Secret, error)
+	RenewLease(path, leaseID string, increment time.Duration) (*api.Secret, error)
+	RevokeLease(path, leaseID string) error
+	TransitDecrypt(mount, key, ciphertext string) ([]byte, error)
+	WriteSecret(path string, data map[string]interface{}) (*api.Secret, error)
+	Unwrap(token string) (*api.Secret, error)
+	ReadWrapped(path string, ttl time.Duration) (*api.SecretWrapInfo, error)
 }
 
 // vaultClient is the concrete implementation of vaultIO interacting with a real Vault server
 type vaultClient struct {
-	client *api.Client
-	config *vaultBackend
-	token  string
+	client  *api.Client   // the current cluster, read with api()
+	clients []*api.Client // one per address, in order of preference
+	current int           // index of client in clients
+	checked time.Time     // last check whether a more preferred cluster is healthy
+	probing bool          // a check whether a more preferred cluster is healthy is in progress
+	mu      sync.Mutex    // protects client, current, checked and probing
+	config  *vaultBackend
+	breaker *circuitBreaker // nil if disabled
+
+	tokenMu  sync.RWMutex // protects token, relogin and tokenGen
+	token    string
+	relogin  func() error // repeats the last login, if the token was obtained with an auth method
+	tokenGen uint64       // incremented whenever the token changes
+	loginMu  sync.Mutex   // serializes logging in again, so concurrent requests rejected with a token log in once
 }
 
 var _ vaultIO = &vaultClient{}
@@ -103,25 +292,65 @@ var getVaultClient vaultClientFactory = newVaultClient
 // newVaultClient returns a vaultClient object or error
 func newVaultClient(config *vaultBackend) (vaultIO, error) {
 	vc := vaultClient{}
-	c, err := api.NewClient(&api.Config{Address: config.host})
-	vc.client = c
+	for _, addr := range config.addresses() {
+		c, err := newVaultAPIClient(config, addr)
+		if err != nil {
+			return nil, fmt.Errorf("error creating client for %v: %w", addr, err)
+		}
+		vc.clients = append(vc.clients, c)
+	}
+	vc.client = vc.clients[0]
 	vc.config = config
-	return &vc, err
+	if len(vc.clients) > 1 {
+		vc.failover(nil)
+	}
+	if config.breakerThreshold > 0 {
+		vc.breaker = &circuitBreaker{threshold: config.breakerThreshold, cooldown: config.breakerCooldown}
+	}
+	return &vc, nil
+}
+
+// newVaultAPIClient returns a Vault API client for addr. Each address gets its own HTTP client, since the transport
+// depends on the address (eg, for Unix sockets).
+func newVaultAPIClient(config *vaultBackend, addr string) (*api.Client, error) {
+	// the retry policy retries requests instead of the API client, which would also retry requests that aren't idempotent
+	apiConfig := &api.Config{Address: addr, Timeout: config.timeout, MaxRetries: 0}
+	if config.tls != nil {
+		// the same HTTP client NewClient would otherwise use
+		def := api.DefaultConfig()
+		if def.Error != nil {
+			return nil, fmt.Errorf("error getting default Vault configuration: %v", def.Error)
+		}
+		apiConfig.HttpClient = def.HttpClient
+		if err := config.tls.apply(apiConfig); err != nil {
+			return nil, fmt.Errorf("error configuring TLS: %v", err)
+		}
+	}
+	c, err := api.NewClient(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	if config.namespace != "" {
+		c.SetNamespace(config.namespace)
+	}
+	return c, nil
 }
 
 // tokenAuth sets the client token but doesn't check validity
 func (c *vaultClient) TokenAuth(token string) error {
-	c.token = token
-	c.client.SetToken(token)
-	ta := c.client.Auth().Token()
+	c.setToken(token, nil)
+	client := c.api()
+	client.SetToken(token)
+	ta := client.Auth().Token()
 	var err error
 	for i := 0; i <= int(c.config.authRetries); i++ {
 		_, err = ta.LookupSelf()
 		if err == nil {
 			break
 		}
-		log.Printf("Token auth failed: %v, retrying (%v/%v)", err, i+1, c.config.authRetries)
-		time.Sleep(time.Duration(c.config.authRetryDelaySecs) * time.Second)
+		loggerOrDefault(c.config.logger).Warn("Vault token auth failed, retrying",
+			"error", err, "attempt", i+1, "retries", c.config.authRetries)
+		time.Sleep(c.authRetryDelay())
 	}
 	if err != nil {
 		return fmt.Errorf("error performing auth call to Vault (retries exceeded): %v", err)
@@ -130,24 +359,37 @@ func (c *vaultClient) TokenAuth(token string) error {
 }
 
 func (c *vaultClient) getTokenAndConfirm(route string, payload interface{}) error {
+	return c.login(route, func() (interface{}, error) { return payload, nil })
+}
+
+// login performs a login request to route, building the payload for each attempt, and records it so the client can log
+// in again if the token is rejected later
+func (c *vaultClient) login(route string, payload func() (interface{}, error)) error {
 	var resp *api.Response
 	var err error
 	for i := 0; i <= int(c.config.authRetries); i++ {
-		req := c.client.NewRequest("POST", route)
-		jerr := req.SetJSONBody(payload)
-		if jerr != nil {
-			return fmt.Errorf("error setting auth JSON body: %v", jerr)
-		}
-		resp, err = c.client.RawRequest(req)
+		var p interface{}
+		p, err = payload()
 		if err == nil {
-			break
+			client := c.api()
+			req := client.NewRequest("POST", route)
+			jerr := req.SetJSONBody(p)
+			if jerr != nil {
+				return fmt.Errorf("error setting auth JSON body: %v", jerr)
+			}
+			resp, err = client.RawRequest(req)
+			if err == nil {
+				break
+			}
 		}
-		log.Printf("auth failed: %v, retrying (%v/%v)", err, i+1, c.config.authRetries)
-		time.Sleep(time.Duration(c.config.authRetryDelaySecs) * time.Second)
+		loggerOrDefault(c.config.logger).Warn("Vault auth failed, retrying",
+			"route", route, "error", err, "attempt", i+1, "retries", c.config.authRetries)
+		time.Sleep(c.authRetryDelay())
 	}
 	if err != nil {
 		return fmt.Errorf("error performing auth call to Vault (retries exceeded): %v", err)
 	}
+	defer resp.Body.Close()
 
 	var output interface{}
 	jd := json.NewDecoder(resp.Body)
@@ -155,42 +397,292 @@ func (c *vaultClient) getTokenAndConfirm(route string, payload interface{}) erro
 	if err != nil {
 		return fmt.Errorf("error unmarshaling Vault auth response: %v", err)
 	}
-	body := output.(map[string]interface{})
-	auth := body["auth"].(map[string]interface{})
-	c.token = auth["client_token"].(string)
+	body, _ := output.(map[string]interface{})
+	auth, _ := body["auth"].(map[string]interface{})
+	token, _ := auth["client_token"].(string)
+	if token == "" {
+		return fmt.Errorf("Vault auth response is missing a client token")
+	}
+	c.setToken(token, func() error { return c.login(route, payload) })
 	return nil
 }
 
-func (c *vaultClient) AppRoleAuth(roleid string) error {
-	return nil
+// setToken sets the token used for requests, and the function to obtain a new one (nil if the token can't be renewed
+// by logging in again)
+func (c *vaultClient) setToken(token string, relogin func() error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.token = token
+	c.relogin = relogin
+	c.tokenGen++
+}
+
+// currentToken returns the token used for requests and its generation
+func (c *vaultClient) currentToken() (string, uint64) {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.token, c.tokenGen
+}
+
+// do calls f, an idempotent request to Vault such as reading a static secret, subject to the circuit breaker and retry
+// policy, logging in again if necessary
+func (c *vaultClient) do(f func() error) error {
+	return c.request(true, f)
+}
+
+// doOnce calls f, a request to Vault that isn't idempotent such as issuing a certificate or dynamic secret, subject to
+// the circuit breaker, logging in again if necessary
+func (c *vaultClient) doOnce(f func() error) error {
+	return c.request(false, f)
+}
+
+// request calls f subject to the circuit breaker. If the request is idempotent, transient errors are retried according to
+// the retry policy and on another cluster; otherwise they are returned, since the request may have taken effect. Requests
+// rejected because the token is no longer valid are repeated after logging in again in either case.
+func (c *vaultClient) request(idempotent bool, f func() error) error {
+	retryable := retryableVaultError
+	if c.config.retryPolicy.Retryable != nil {
+		retryable = c.config.retryPolicy.Retryable
+	}
+	return c.breaker.do(retryable, func() error {
+		attempt := func() error {
+			return c.withFailover(retryable, idempotent, func() error {
+				return c.withRelogin(f)
+			})
+		}
+		if !idempotent {
+			return attempt()
+		}
+		return c.config.retryPolicy.do(retryableVaultError, attempt)
+	})
+}
+
+// authRetryDelay returns the delay between authentication attempts
+func (c *vaultClient) authRetryDelay() time.Duration {
+	if c.config.authRetryDelay > 0 {
+		return c.config.authRetryDelay
+	}
+	return time.Duration(c.config.authRetryDelaySecs) * time.Second
+}
+
+// retryableVaultError reports whether err is likely transient: a connection failure or timeout, or a response indicating
+// that Vault is unavailable (5xx, such as during a leader election), rate limited (429), or that a performance standby
+// hasn't caught up yet (412)
+func retryableVaultError(err error) bool {
+	var rerr *api.ResponseError
+	if errors.As(err, &rerr) {
+		switch rerr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusPreconditionFailed:
+			return true
+		}
+		return rerr.StatusCode >= 500
+	}
+	var operr *net.OpError
+	if errors.As(err, &operr) {
+		return true
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// withRelogin calls f and, if Vault rejects the token as forbidden and the client logged in with an auth method, logs in
+// again and calls f once more
+func (c *vaultClient) withRelogin(f func() error) error {
+	_, gen := c.currentToken()
+	err := f()
+	var rerr *api.ResponseError
+	if err == nil || !errors.As(err, &rerr) || rerr.StatusCode != http.StatusForbidden {
+		return err
+	}
+	ok, lerr := c.reloginOnce(gen)
+	if !ok {
+		return err
+	}
+	if lerr != nil {
+		return fmt.Errorf("%w (error logging in again: %v)", err, lerr)
+	}
+	return f()
+}
+
+// reloginOnce logs in again if the token is still generation gen, and reports whether a new token may be available. If
+// another request has already obtained a new token in the meantime, it returns without logging in.
+func (c *vaultClient) reloginOnce(gen uint64) (bool, error) {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	c.tokenMu.RLock()
+	relogin, cur := c.relogin, c.tokenGen
+	c.tokenMu.RUnlock()
+	if cur != gen {
+		return true, nil
+	}
+	if relogin == nil {
+		return false, nil
+	}
+	return true, relogin()
 }
 
+func (c *vaultClient) AppRoleAuth(roleid, secretid string) error {
+	if roleid == "" {
+		return fmt.Errorf("role ID is required")
+	}
+	payload := struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id,omitempty"`
+	}{
+		RoleID:   roleid,
+		SecretID: secretid,
+	}
+	return c.getTokenAndConfirm(fmt.Sprintf("/v1/auth/%v/login", authPath(c.config.approleauthpath, "approle")), &payload)
+}
+
+// K8sAuth logs in with the kubernetes auth method. If jwt is empty, the service account token is read from the token
+// file (see WithVaultK8sAuthTokenFile) on every login attempt, so rotated tokens are picked up.
 func (c *vaultClient) K8sAuth(jwt, roleid string) error {
+	if c.config.k8sauthpath == "" {
+		c.config.k8sauthpath = "kubernetes"
+	}
+	tokenFile := c.config.k8sjwtfile
+	if tokenFile == "" {
+		tokenFile = DefaultK8sServiceAccountTokenFile
+	}
+	return c.login(fmt.Sprintf("/v1/auth/%v/login", c.config.k8sauthpath), func() (interface{}, error) {
+		token := jwt
+		if token == "" {
+			b, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading service account token: %v", err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		return &struct {
+			JWT  string `json:"jwt"`
+			Role string `json:"role"`
+		}{
+			JWT:  token,
+			Role: roleid,
+		}, nil
+	})
+}
+
+func (c *vaultClient) JWTAuth(jwt, role string) error {
+	if jwt == "" {
+		return fmt.Errorf("JWT is required")
+	}
 	payload := struct {
 		JWT  string `json:"jwt"`
-		Role string `json:"role"`
+		Role string `json:"role,omitempty"`
 	}{
 		JWT:  jwt,
-		Role: roleid,
+		Role: role,
 	}
-	if c.config.k8sauthpath == "" {
-		c.config.k8sauthpath = "kubernetes"
+	return c.getTokenAndConfirm(fmt.Sprintf("/v1/auth/%v/login", authPath(c.config.jwtauthpath, "jwt")), &payload)
+}
+
+func (c *vaultClient) UserpassAuth(username, password string) error {
+	return c.passwordAuth(authPath(c.config.userpassauthpath, "userpass"), username, password)
+}
+
+func (c *vaultClient) LDAPAuth(username, password string) error {
+	return c.passwordAuth(authPath(c.config.ldapauthpath, "ldap"), username, password)
+}
+
+// passwordAuth logs in with a username and password to an auth backend that takes the username in the login path
+func (c *vaultClient) passwordAuth(mount, username, password string) error {
+	if username == "" || username == "." || username == ".." || strings.Contains(username, "/") {
+		return fmt.Errorf("invalid username: %q", username)
+	}
+	payload := struct {
+		Password string `json:"password"`
+	}{
+		Password: password,
+	}
+	return c.getTokenAndConfirm(fmt.Sprintf("/v1/auth/%v/login/%v", mount, username), &payload)
+}
+
+// CertAuth logs in with the client certificate presented on the TLS connection
+func (c *vaultClient) CertAuth(role string) error {
+	payload := struct {
+		Name string `json:"name,omitempty"`
+	}{
+		Name: role,
 	}
-	return c.getTokenAndConfirm(fmt.Sprintf("/v1/auth/%v/login", c.config.k8sauthpath), &payload)
+	return c.getTokenAndConfirm(fmt.Sprintf("/v1/auth/%v/login", authPath(c.config.certauthpath, "cert")), &payload)
+}
+
+// authPath returns the auth backend mount path, or def if unset
+func authPath(path, def string) string {
+	if path == "" {
+		return def
+	}
+	return strings.Trim(path, "/")
 }
 
 var DefaultVaultValueKey = "value"
 
-// getValue retrieves value at path
-func (c *vaultClient) getValue(path string) (interface{}, error) {
-	c.client.SetToken(c.token)
-	lc := c.client.Logical()
-	s, err := lc.Read(path)
+// ReadSecret reads the secret at path, or returns an error if it doesn't exist
+func (c *vaultClient) ReadSecret(path string) (*api.Secret, error) {
+	return c.readSecret(path, c.do)
+}
+
+// ReadDynamicSecret reads the secret at path like ReadSecret, but without retrying, since each read of a dynamic secret
+// issues new credentials with their own lease
+func (c *vaultClient) ReadDynamicSecret(path string) (*api.Secret, error) {
+	return c.readSecret(path, c.doOnce)
+}
+
+// readSecret reads the secret at path, making the request with do
+func (c *vaultClient) readSecret(path string, do func(func() error) error) (*api.Secret, error) {
+	var s *api.Secret
+	location := path
+	err := do(func() (err error) {
+		var client *api.Client
+		client, path = c.clientFor(location)
+		s, err = client.Logical().Read(path)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error reading secret from Vault: %v: %v", path, err)
+		return nil, fmt.Errorf("error reading secret from Vault: %v: %w", path, err)
 	}
 	if s == nil {
-		return nil, fmt.Errorf("secret not found")
+		return nil, fmt.Errorf("secret not found: %v", path)
+	}
+	return s, nil
+}
+
+// RenewLease renews a lease issued for the secret at path by increment and returns the renewed lease
+func (c *vaultClient) RenewLease(path, leaseID string, increment time.Duration) (*api.Secret, error) {
+	var s *api.Secret
+	err := c.do(func() (err error) {
+		client, _ := c.clientFor(path)
+		s, err = client.Sys().Renew(leaseID, int(increment/time.Second))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("empty lease renewal response")
+	}
+	return s, nil
+}
+
+// RevokeLease revokes a lease issued for the secret at path
+func (c *vaultClient) RevokeLease(path, leaseID string) error {
+	return c.do(func() error {
+		client, _ := c.clientFor(path)
+		return client.Sys().Revoke(leaseID)
+	})
+}
+
+// getValue retrieves value at path
+func (c *vaultClient) getValue(path string) (interface{}, error) {
+	s, err := c.ReadSecret(path)
+	if err != nil {
+		return nil, err
 	}
 	key := DefaultVaultValueKey
 	if c.config.valuekey != "" {
@@ -202,18 +694,59 @@ func (c *vaultClient) getValue(path string) (interface{}, error) {
 	return s.Data[key], nil
 }
 
-// GetValue retrieves a value
+// GetValue retrieves a value. Values that are not strings (such as numbers or nested objects) are returned as JSON.
 func (c *vaultClient) GetValue(path string) ([]byte, error) {
 	val, err := c.getValue(path)
 	if err != nil {
 		return nil, err
 	}
-	switch val := val.(type) {
-	case []byte:
-		return val, nil
-	case string:
-		return []byte(val), nil
-	default:
-		return nil, fmt.Errorf("unexpected type for %v value: %T", path, val)
+	v, err := stringValue(val)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %v value: %v", path, err)
+	}
+	return []byte(v), nil
+}
+
+// ListKeys returns the keys directly under path. Keys ending in "/" are sub-paths. A path with no keys returns an empty slice.
+func (c *vaultClient) ListKeys(path string) ([]string, error) {
+	var s *api.Secret
+	location := path
+	err := c.do(func() (err error) {
+		var client *api.Client
+		client, path = c.clientFor(location)
+		s, err = client.Logical().List(path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing secrets in Vault: %v: %w", path, err)
+	}
+	if s == nil {
+		return []string{}, nil
+	}
+	keys, ok := s.Data["keys"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected type for %v keys: %T", path, s.Data["keys"])
+	}
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected type for %v key: %T", path, k)
+		}
+		out = append(out, ks)
+	}
+	return out, nil
+}
+
+// clientFor sets the client token and returns the API client and path to use for a mapped location, which may override
+// the namespace with VaultNamespaceSeparator
+func (c *vaultClient) clientFor(location string) (*api.Client, string) {
+	client := c.api()
+	token, _ := c.currentToken()
+	client.SetToken(token)
+	ns, path, ok := strings.Cut(location, VaultNamespaceSeparator)
+	if !ok {
+		return client, location
 	}
+	return client.WithNamespace(ns), path
 }
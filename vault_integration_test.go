@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"testing"
+
+	"github.com/hashicorp/vault/api"
 )
 
 const (
@@ -45,11 +47,52 @@ func TestVaultIntegrationAppRoleAuth(t *testing.T) {
 		t.Skipf("TEST_VAULT_ADDR undefined, skipping")
 		return
 	}
+	roleid, secretid := testAppRole(t)
 	vc := testGetVaultClient(t)
-	err := vc.AppRoleAuth("")
+	err := vc.AppRoleAuth(roleid, secretid)
 	if err != nil {
-		log.Fatalf("error authenticating: %v", err)
+		t.Fatalf("error authenticating: %v", err)
+	}
+	s, err := vc.GetValue(testSecretPath)
+	if err != nil {
+		t.Fatalf("error getting value with AppRole token: %v", err)
+	}
+	if string(s) != "foo" {
+		t.Fatalf("bad value: %v (expected 'foo')", string(s))
+	}
+}
+
+// testAppRole enables the approle auth method if necessary, using the root token, and creates a role with the test
+// policy. It returns the role ID and a new secret ID.
+func testAppRole(t *testing.T) (string, string) {
+	client, err := api.NewClient(&api.Config{Address: testvb.host})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client.SetToken(testvb.token)
+	auths, err := client.Sys().ListAuth()
+	if err != nil {
+		t.Fatalf("error listing auth methods: %v", err)
+	}
+	if _, ok := auths["approle/"]; !ok {
+		if err := client.Sys().EnableAuthWithOptions("approle", &api.EnableAuthOptions{Type: "approle"}); err != nil {
+			t.Fatalf("error enabling approle auth: %v", err)
+		}
+	}
+	if _, err := client.Logical().Write("auth/approle/role/pvc-test", map[string]interface{}{"policies": "tpol"}); err != nil {
+		t.Fatalf("error creating role: %v", err)
+	}
+	s, err := client.Logical().Read("auth/approle/role/pvc-test/role-id")
+	if err != nil || s == nil {
+		t.Fatalf("error reading role ID: %v", err)
+	}
+	roleid, _ := s.Data["role_id"].(string)
+	s, err = client.Logical().Write("auth/approle/role/pvc-test/secret-id", nil)
+	if err != nil || s == nil {
+		t.Fatalf("error creating secret ID: %v", err)
 	}
+	secretid, _ := s.Data["secret_id"].(string)
+	return roleid, secretid
 }
 
 func TestVaultIntegrationGetValue(t *testing.T) {
@@ -0,0 +1,731 @@
+package pvc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// newTestVaultClient returns a vaultClient connected to an httptest server using handler, authenticated with a dummy token
+func newTestVaultClient(t *testing.T, vb *vaultBackend, handler http.Handler) *vaultClient {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	vb.host = srv.URL
+	vc, err := newVaultClient(vb)
+	if err != nil {
+		t.Fatalf("error creating vault client: %v", err)
+	}
+	c := vc.(*vaultClient)
+	c.token = "dummy"
+	return c
+}
+
+// writeVaultJSON writes v as a JSON response body
+func writeVaultJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("error writing response: %v", err)
+	}
+}
+
+func TestVaultBackendGetterList(t *testing.T) {
+	vb := &vaultBackend{
+		host:           "foo",
+		authentication: TokenVaultAuth,
+		mapping:        "secret/development/{{ .ID }}",
+	}
+	fv := &fakeVaultIO{
+		keys: map[string][]string{
+			"secret/development/":              {"db/", "tenants/", "api_key"},
+			"secret/development/db/":           {"password", "username"},
+			"secret/development/tenants/":      {"acme/", "globex"},
+			"secret/development/tenants/acme/": {"token"},
+		},
+	}
+	vbg, err := newVaultBackendGetter(vb, fv)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	ids, err := vbg.List("")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	want := []string{"api_key", "db/password", "db/username", "tenants/acme/token", "tenants/globex"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+	ids, err = vbg.List("tenants/g")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"tenants/globex"}) {
+		t.Fatalf("bad ids: %v", ids)
+	}
+}
+
+func TestVaultBackendGetterGetSpecialCharacters(t *testing.T) {
+	vb := &vaultBackend{
+		authentication: TokenVaultAuth,
+		mapping:        "secret/{{ .ID }}",
+	}
+	id := "db/p&ss+w'rd <1>?#%"
+	var gotPath string
+	vc := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "foo"}})
+	}))
+	vbg, err := newVaultBackendGetter(vb, vc)
+	if err != nil {
+		t.Fatalf("should have succeeded: %v", err)
+	}
+	v, err := vbg.Get(id)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if string(v) != "foo" {
+		t.Fatalf("bad value: %v", string(v))
+	}
+	if gotPath != "/v1/secret/"+id {
+		t.Fatalf("bad path received by server: %v", gotPath)
+	}
+	if _, err := vbg.Get("db/../../sys/raw"); err == nil {
+		t.Fatalf("relative path segments should have been rejected")
+	}
+	if _, err := vbg.Get("x::secret/other"); err == nil || !strings.Contains(err.Error(), "namespace separator") {
+		t.Fatalf("namespace separator should have been rejected: %v", err)
+	}
+}
+
+// testCertificate generates a self-signed certificate and key for commonName, returning them PEM-encoded
+func testCertificate(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshaling key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+}
+
+func TestNewVaultClientTLS(t *testing.T) {
+	serverCert, serverKey := testCertificate(t, "vault.internal")
+	clientCert, clientKey := testCertificate(t, "client")
+	dir := t.TempDir()
+	files := map[string][]byte{"ca.pem": serverCert, "client.pem": clientCert, "client-key.pem": clientKey}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), contents, 0600); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+	kp, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("error loading server key pair: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientCert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "foo"}})
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{kp},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MaxVersion:   tls.VersionTLS12,
+	}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // expected handshake failures
+	srv.StartTLS()
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		tls     *VaultTLSConfig
+		wantErr bool
+	}{
+		{name: "no tls config", wantErr: true},
+		{
+			name: "ca file and client cert",
+			tls: &VaultTLSConfig{
+				CACertFile:     filepath.Join(dir, "ca.pem"),
+				ClientCertFile: filepath.Join(dir, "client.pem"),
+				ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+				ServerName:     "vault.internal",
+			},
+		},
+		{
+			name: "ca pem and client cert",
+			tls: &VaultTLSConfig{
+				CACertPEM:      serverCert,
+				ClientCertFile: filepath.Join(dir, "client.pem"),
+				ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+				ServerName:     "vault.internal",
+			},
+		},
+		{
+			name: "wrong server name",
+			tls: &VaultTLSConfig{
+				CACertPEM:      serverCert,
+				ClientCertFile: filepath.Join(dir, "client.pem"),
+				ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+				ServerName:     "other.internal",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing client cert",
+			tls: &VaultTLSConfig{
+				CACertPEM:  serverCert,
+				ServerName: "vault.internal",
+			},
+			wantErr: true,
+		},
+		{
+			name: "insecure",
+			tls: &VaultTLSConfig{
+				ClientCertFile:     filepath.Join(dir, "client.pem"),
+				ClientKeyFile:      filepath.Join(dir, "client-key.pem"),
+				InsecureSkipVerify: true,
+			},
+		},
+		{
+			name: "min version too high",
+			tls: &VaultTLSConfig{
+				CACertPEM:      serverCert,
+				ClientCertFile: filepath.Join(dir, "client.pem"),
+				ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+				ServerName:     "vault.internal",
+				MinVersion:     tls.VersionTLS13,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vc, err := newVaultClient(&vaultBackend{host: srv.URL, tls: tt.tls})
+			if err != nil {
+				t.Fatalf("error creating vault client: %v", err)
+			}
+			vc.(*vaultClient).token = "dummy"
+			_, err = vc.GetValue("secret/foo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewVaultClientTLSInvalidClientCert(t *testing.T) {
+	_, err := newVaultClient(&vaultBackend{
+		host: "https://vault.internal:8200",
+		tls:  &VaultTLSConfig{ClientCertFile: "missing.pem"},
+	})
+	if err == nil {
+		t.Fatalf("should have failed")
+	}
+}
+
+func TestVaultClientNamespace(t *testing.T) {
+	namespaces := map[string]string{}
+	vb := &vaultBackend{namespace: "engineering"}
+	vc := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespaces[r.URL.Path] = r.Header.Get("X-Vault-Namespace")
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "foo"}})
+		default:
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "foo"}})
+		}
+	}))
+	if err := vc.K8sAuth("jwt", "role"); err != nil {
+		t.Fatalf("error authenticating: %v", err)
+	}
+	for _, path := range []string{"secret/a", "engineering/team-a::secret/b", "::secret/c"} {
+		if _, err := vc.GetValue(path); err != nil {
+			t.Fatalf("error getting value: %v", err)
+		}
+	}
+	want := map[string]string{
+		"/v1/auth/kubernetes/login": "engineering",
+		"/v1/secret/a":              "engineering",
+		"/v1/secret/b":              "engineering/team-a",
+		"/v1/secret/c":              "",
+	}
+	if !reflect.DeepEqual(namespaces, want) {
+		t.Fatalf("bad namespaces: %v", namespaces)
+	}
+	if _, err := vc.GetValue("secret/d"); err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if namespaces["/v1/secret/d"] != "engineering" {
+		t.Fatalf("per-secret namespace should not persist: %v", namespaces["/v1/secret/d"])
+	}
+}
+
+func TestVaultClientLoginMethods(t *testing.T) {
+	tests := []struct {
+		name     string
+		vb       *vaultBackend
+		login    func(c *vaultClient) error
+		wantPath string
+		wantBody map[string]string
+	}{
+		{
+			name:     "jwt",
+			vb:       &vaultBackend{},
+			login:    func(c *vaultClient) error { return c.JWTAuth("ci.jwt", "deploy") },
+			wantPath: "/v1/auth/jwt/login",
+			wantBody: map[string]string{"jwt": "ci.jwt", "role": "deploy"},
+		},
+		{
+			name:     "jwt custom path",
+			vb:       &vaultBackend{jwtauthpath: "gitlab"},
+			login:    func(c *vaultClient) error { return c.JWTAuth("ci.jwt", "deploy") },
+			wantPath: "/v1/auth/gitlab/login",
+			wantBody: map[string]string{"jwt": "ci.jwt", "role": "deploy"},
+		},
+		{
+			name:     "userpass",
+			vb:       &vaultBackend{},
+			login:    func(c *vaultClient) error { return c.UserpassAuth("alice", "hunter2") },
+			wantPath: "/v1/auth/userpass/login/alice",
+			wantBody: map[string]string{"password": "hunter2"},
+		},
+		{
+			name:     "ldap custom path",
+			vb:       &vaultBackend{ldapauthpath: "corp-ldap/"},
+			login:    func(c *vaultClient) error { return c.LDAPAuth("alice", "hunter2") },
+			wantPath: "/v1/auth/corp-ldap/login/alice",
+			wantBody: map[string]string{"password": "hunter2"},
+		},
+		{
+			name:     "cert",
+			vb:       &vaultBackend{},
+			login:    func(c *vaultClient) error { return c.CertAuth("web") },
+			wantPath: "/v1/auth/cert/login",
+			wantBody: map[string]string{"name": "web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			var gotBody map[string]string
+			c := newTestVaultClient(t, tt.vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+					t.Errorf("error decoding request: %v", err)
+				}
+				writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "logintoken"}})
+			}))
+			if err := tt.login(c); err != nil {
+				t.Fatalf("login failed: %v", err)
+			}
+			if gotPath != tt.wantPath {
+				t.Fatalf("bad path: %v (wanted %v)", gotPath, tt.wantPath)
+			}
+			if !reflect.DeepEqual(gotBody, tt.wantBody) {
+				t.Fatalf("bad body: %v (wanted %v)", gotBody, tt.wantBody)
+			}
+			if c.token != "logintoken" {
+				t.Fatalf("bad token: %v", c.token)
+			}
+		})
+	}
+}
+
+func TestVaultClientLoginErrors(t *testing.T) {
+	c := newTestVaultClient(t, &vaultBackend{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"invalid username or password"}})
+	}))
+	if err := c.UserpassAuth("alice", "wrong"); err == nil {
+		t.Fatalf("expected error for rejected login")
+	}
+	if err := c.UserpassAuth("../token", "hunter2"); err == nil || !strings.Contains(err.Error(), "invalid username") {
+		t.Fatalf("expected invalid username error, received: %v", err)
+	}
+	if err := c.JWTAuth("", "deploy"); err == nil {
+		t.Fatalf("expected error for missing JWT")
+	}
+
+	getVaultClient = newFakeVaultClient
+	defer func() { getVaultClient = newVaultClient }()
+	_, err := NewSecretsClient(WithVaultBackend(CertVaultAuth, "foo"), WithVaultCertAuth("web"))
+	if err == nil || !strings.Contains(err.Error(), "client certificate") {
+		t.Fatalf("expected missing client certificate error, received: %v", err)
+	}
+}
+
+func TestVaultClientK8sAuthTokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("jwt-1\n"), 0600); err != nil {
+		t.Fatalf("error writing token file: %v", err)
+	}
+	var logins []string
+	vb := &vaultBackend{k8sjwtfile: tokenFile}
+	c := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var payload map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("error decoding request: %v", err)
+			}
+			logins = append(logins, payload["jwt"])
+			writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": payload["jwt"] + "-token"}})
+		case "/v1/secret/foo":
+			// the token from the first login has expired
+			if r.Header.Get("X-Vault-Token") != "jwt-2-token" {
+				w.WriteHeader(http.StatusForbidden)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "bar"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	if err := c.K8sAuth("", "web"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	// the projected token is rotated by the kubelet
+	if err := os.WriteFile(tokenFile, []byte("jwt-2\n"), 0600); err != nil {
+		t.Fatalf("error writing token file: %v", err)
+	}
+	v, err := c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "bar" {
+		t.Fatalf("bad value: %v", string(v))
+	}
+	if !reflect.DeepEqual(logins, []string{"jwt-1", "jwt-2"}) {
+		t.Fatalf("bad logins: %v", logins)
+	}
+}
+
+func TestVaultClientK8sAuthTokenFileRetries(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	var logins []string
+	vb := &vaultBackend{k8sjwtfile: tokenFile, authRetries: 1}
+	c := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+		logins = append(logins, payload["jwt"])
+		if payload["jwt"] == "stale" {
+			// the token is rotated before the next attempt
+			if err := os.WriteFile(tokenFile, []byte("fresh"), 0600); err != nil {
+				t.Errorf("error writing token file: %v", err)
+			}
+			w.WriteHeader(http.StatusForbidden)
+			writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "token"}})
+	}))
+	if err := c.K8sAuth("", "web"); err == nil || !strings.Contains(err.Error(), "service account token") {
+		t.Fatalf("expected error reading token file, received: %v", err)
+	}
+	if len(logins) != 0 {
+		t.Fatalf("unexpected logins: %v", logins)
+	}
+
+	if err := os.WriteFile(tokenFile, []byte("stale"), 0600); err != nil {
+		t.Fatalf("error writing token file: %v", err)
+	}
+	if err := c.K8sAuth("", "web"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if !reflect.DeepEqual(logins, []string{"stale", "fresh"}) || c.token != "token" {
+		t.Fatalf("bad logins: %v (token: %v)", logins, c.token)
+	}
+}
+
+func TestVaultMultiKeySecrets(t *testing.T) {
+	vb := &vaultBackend{authentication: TokenVaultAuth, token: "dummy", mapping: "secret/{{ .ID }}"}
+	c := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"id": "dummy"}})
+		case "/v1/secret/db":
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{
+				"username": "app",
+				"password": "pa55w0rd",
+				"port":     5432,
+				"value":    map[string]interface{}{"sslmode": "require"},
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	vbg, err := newVaultBackendGetter(vb, c)
+	if err != nil {
+		t.Fatalf("error creating backend: %v", err)
+	}
+	sc := &SecretsClient{backend: vbg}
+
+	m, err := sc.GetMap("db")
+	if err != nil {
+		t.Fatalf("error getting map: %v", err)
+	}
+	if m["username"] != "app" || m["password"] != "pa55w0rd" || m["port"] != json.Number("5432") {
+		t.Fatalf("bad map: %v", m)
+	}
+
+	// nested values are returned as JSON
+	v, err := sc.Get("db")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != `{"sslmode":"require"}` {
+		t.Fatalf("bad value: %v", string(v))
+	}
+
+	s := struct {
+		Username string `secret:"db#username"`
+		Port     string `secret:"db#port"`
+	}{}
+	if err := sc.Fill(&s); err != nil {
+		t.Fatalf("error filling struct: %v", err)
+	}
+	if s.Username != "app" || s.Port != "5432" {
+		t.Fatalf("bad values: %+v", s)
+	}
+
+	if _, err := sc.GetMap("missing"); err == nil {
+		t.Fatalf("expected error for missing secret")
+	}
+}
+
+func TestVaultClientRetries(t *testing.T) {
+	attempts := 0
+	vb := &vaultBackend{retryPolicy: RetryPolicy{MaxRetries: 3, InitialDelay: time.Millisecond}}
+	c := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch r.URL.Path {
+		case "/v1/secret/foo":
+			// Vault is electing a new leader
+			if attempts < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"Vault is sealed"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "bar"}})
+		case "/v1/pki/issue/web", "/v1/database/creds/readonly", "/v1/secret/wrapped", "/v1/transit/decrypt/app":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"Vault is sealed"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	v, err := c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "bar" || attempts != 3 {
+		t.Fatalf("bad value: %v (attempts: %v)", string(v), attempts)
+	}
+
+	// not found is not retried
+	attempts = 0
+	if _, err := c.GetValue("secret/missing"); err == nil || attempts != 1 {
+		t.Fatalf("expected a single attempt for a missing secret: %v (attempts: %v)", err, attempts)
+	}
+
+	// requests with side effects are not retried
+	attempts = 0
+	if _, err := c.WriteSecret("pki/issue/web", map[string]interface{}{"common_name": "web.internal"}); err == nil || attempts != 1 {
+		t.Fatalf("expected a single attempt to issue a certificate: %v (attempts: %v)", err, attempts)
+	}
+	attempts = 0
+	if _, err := c.ReadDynamicSecret("database/creds/readonly"); err == nil || attempts != 1 {
+		t.Fatalf("expected a single attempt to read a dynamic secret: %v (attempts: %v)", err, attempts)
+	}
+	attempts = 0
+	if _, err := c.ReadWrapped("secret/wrapped", time.Minute); err == nil || attempts != 1 {
+		t.Fatalf("expected a single attempt to read a wrapped secret: %v (attempts: %v)", err, attempts)
+	}
+
+	// decryption has no side effects
+	attempts = 0
+	if _, err := c.TransitDecrypt("transit", "app", "vault:v1:abc"); err == nil || attempts != 4 {
+		t.Fatalf("expected decryption to be retried: %v (attempts: %v)", err, attempts)
+	}
+	if n := c.api().MaxRetries(); n != 0 {
+		t.Fatalf("the API client should not retry requests: %v", n)
+	}
+}
+
+func TestVaultClientCircuitBreaker(t *testing.T) {
+	// nothing is listening on the address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	vb := &vaultBackend{
+		host:             "http://" + addr,
+		retryPolicy:      RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond},
+		breakerThreshold: 2,
+		breakerCooldown:  time.Minute,
+	}
+	vc, err := newVaultClient(vb)
+	if err != nil {
+		t.Fatalf("error creating vault client: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := vc.GetValue("secret/foo"); err == nil || errors.Is(err, ErrCircuitOpen) || !retryableVaultError(err) {
+			t.Fatalf("expected connection error, received: %v", err)
+		}
+	}
+	if _, err := vc.GetValue("secret/foo"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected circuit breaker error, received: %v", err)
+	}
+}
+
+func TestVaultClientConcurrentRelogin(t *testing.T) {
+	var mu sync.Mutex
+	logins := 0
+	c := newTestVaultClient(t, &vaultBackend{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			logins++
+			writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": fmt.Sprintf("token-%v", logins)}})
+		case "/v1/secret/foo":
+			// only the token from the latest login is valid
+			if r.Header.Get("X-Vault-Token") != fmt.Sprintf("token-%v", logins) || logins < 2 {
+				w.WriteHeader(http.StatusForbidden)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "bar"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	if err := c.AppRoleAuth("role", "secret"); err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetValue("secret/foo"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("error getting value: %v", err)
+	}
+	if logins != 2 {
+		t.Fatalf("expected a single login after the token was rejected, received %v logins", logins-1)
+	}
+}
+
+func TestRetryableVaultError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&api.ResponseError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&api.ResponseError{StatusCode: http.StatusInternalServerError}, true},
+		{&api.ResponseError{StatusCode: http.StatusTooManyRequests}, true},
+		{&api.ResponseError{StatusCode: http.StatusForbidden}, false},
+		{&api.ResponseError{StatusCode: http.StatusBadRequest}, false},
+		{fmt.Errorf("error: %w", syscall.ECONNRESET), true},
+		{io.ErrUnexpectedEOF, true},
+		{ErrCircuitOpen, false},
+		{errors.New("secret not found"), false},
+	}
+	for _, tt := range tests {
+		if got := retryableVaultError(tt.err); got != tt.want {
+			t.Fatalf("%v: got %v, wanted %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNewSecretsClientVaultAgentUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the agent adds its auto-auth token
+		if tok := r.Header.Get("X-Vault-Token"); tok != "" {
+			t.Errorf("unexpected token: %v", tok)
+		}
+		switch r.URL.Path {
+		case "/v1/secret/foo":
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "bar"}})
+		default:
+			t.Errorf("unexpected request: %v", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	sc, err := NewSecretsClient(
+		WithVaultBackend(AgentVaultAuth, "unix://"+sock),
+		WithMapping("secret/{{ .ID }}"),
+	)
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	v, err := sc.Get("foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "bar" {
+		t.Fatalf("bad value: %v", string(v))
+	}
+}
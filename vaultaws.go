@@ -0,0 +1,237 @@
+package pvc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Defaults for AWS IAM authentication
+const (
+	DefaultVaultAWSSTSEndpoint = "https://sts.amazonaws.com"
+	DefaultVaultAWSSTSRegion   = "us-east-1"
+)
+
+// AWSMetadataEndpointEnvVar overrides the EC2 instance metadata service endpoint used to obtain instance credentials
+// (the same variable honored by the AWS SDKs)
+const AWSMetadataEndpointEnvVar = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
+
+const (
+	defaultAWSMetadataEndpoint = "http://169.254.169.254"
+	awsGetCallerIdentityBody   = "Action=GetCallerIdentity&Version=2011-06-15"
+	cloudMetadataTimeout       = 10 * time.Second
+)
+
+// awsCredentials are the AWS credentials used to sign the sts:GetCallerIdentity request
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"Token"`
+}
+
+// AWSAuth logs in with the aws auth method by presenting an sts:GetCallerIdentity request signed with the credentials from
+// the environment (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN) or, failing that, the EC2 instance profile.
+// The request is signed again for each login, since signatures expire and instance credentials rotate.
+func (c *vaultClient) AWSAuth(role string) error {
+	return c.login(fmt.Sprintf("/v1/auth/%v/login", authPath(c.config.awsauthpath, "aws")), func() (interface{}, error) {
+		return c.awsLoginPayload(role)
+	})
+}
+
+// awsLoginPayload returns the aws login payload with a newly signed sts:GetCallerIdentity request
+func (c *vaultClient) awsLoginPayload(role string) (interface{}, error) {
+	creds, err := awsCredentialsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		creds, err = awsCredentialsFromMetadata()
+		if err != nil {
+			return nil, fmt.Errorf("error getting instance credentials: %v", err)
+		}
+	}
+	endpoint, region := c.config.awsstsendpoint, c.config.awsstsregion
+	if endpoint == "" {
+		endpoint = DefaultVaultAWSSTSEndpoint
+	}
+	if region == "" {
+		region = DefaultVaultAWSSTSRegion
+	}
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(awsGetCallerIdentityBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating STS request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if c.config.awsserverid != "" {
+		req.Header.Set("X-Vault-AWS-IAM-Server-ID", c.config.awsserverid)
+	}
+	signAWSv4(req, []byte(awsGetCallerIdentityBody), creds, region, "sts", time.Now())
+	headers, err := json.Marshal(req.Header)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling STS request headers: %v", err)
+	}
+	return &struct {
+		Method  string `json:"iam_http_request_method"`
+		URL     string `json:"iam_request_url"`
+		Body    string `json:"iam_request_body"`
+		Headers string `json:"iam_request_headers"`
+		Role    string `json:"role,omitempty"`
+	}{
+		Method:  req.Method,
+		URL:     base64.StdEncoding.EncodeToString([]byte(req.URL.String())),
+		Body:    base64.StdEncoding.EncodeToString([]byte(awsGetCallerIdentityBody)),
+		Headers: base64.StdEncoding.EncodeToString(headers),
+		Role:    role,
+	}, nil
+}
+
+// awsCredentialsFromEnvironment returns the credentials in the standard AWS environment variables, or nil if unset
+func awsCredentialsFromEnvironment() (*awsCredentials, error) {
+	creds := &awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	switch {
+	case creds.AccessKeyID == "" && creds.SecretAccessKey == "":
+		return nil, nil
+	case creds.AccessKeyID == "" || creds.SecretAccessKey == "":
+		return nil, fmt.Errorf("both AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	return creds, nil
+}
+
+// awsCredentialsFromMetadata returns the instance profile credentials from the EC2 instance metadata service, using
+// IMDSv2 session tokens when available
+func awsCredentialsFromMetadata() (*awsCredentials, error) {
+	endpoint := os.Getenv(AWSMetadataEndpointEnvVar)
+	if endpoint == "" {
+		endpoint = defaultAWSMetadataEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	hc := &http.Client{Timeout: cloudMetadataTimeout}
+	header := http.Header{}
+	req, err := http.NewRequest("PUT", endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	if token, err := metadataRequest(hc, req); err == nil {
+		header.Set("X-aws-ec2-metadata-token", string(token))
+	}
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequest("GET", endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		return metadataRequest(hc, req)
+	}
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return nil, fmt.Errorf("no instance profile role found")
+	}
+	c, err := get("/latest/meta-data/iam/security-credentials/" + url.PathEscape(role))
+	if err != nil {
+		return nil, err
+	}
+	creds := &awsCredentials{}
+	if err := json.Unmarshal(c, creds); err != nil {
+		return nil, fmt.Errorf("error unmarshaling instance credentials: %v", err)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("instance credentials are incomplete")
+	}
+	return creds, nil
+}
+
+// metadataRequest performs a request against a cloud metadata service and returns the response body
+func metadataRequest(hc *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v %v: unexpected status: %v", req.Method, req.URL.Path, resp.Status)
+	}
+	return body, nil
+}
+
+// signAWSv4 signs req with AWS Signature Version 4, setting the X-Amz-Date, X-Amz-Security-Token (if a session token is
+// present) and Authorization headers. The host and all headers present on req are signed.
+func signAWSv4(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	req.Header.Del("Authorization")
+
+	names := []string{"host"}
+	canonical := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		names = append(names, name)
+		canonical[name] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + canonical[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		headers.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+	req.Body = io.NopCloser(bytes.NewReader(body))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
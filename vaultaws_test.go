@@ -0,0 +1,162 @@
+package pvc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAWSv4(t *testing.T) {
+	// example request from the AWS Signature Version 4 documentation
+	req, err := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWSv4(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("bad authorization header:\n%v\nwanted:\n%v", got, want)
+	}
+}
+
+func TestVaultClientAWSAuth(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("imdstoken"))
+			return
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imdstoken":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("web-instance\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/web-instance":
+			w.Write([]byte(`{"AccessKeyId": "ASIAEXAMPLE", "SecretAccessKey": "secret", "Token": "session"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer imds.Close()
+	t.Setenv(AWSMetadataEndpointEnvVar, imds.URL)
+
+	var payload map[string]string
+	var gotPath string
+	vb := &vaultBackend{awsserverid: "vault.example.com", awsstsendpoint: "https://sts.us-west-2.amazonaws.com", awsstsregion: "us-west-2"}
+	c := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+		writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "awstoken"}})
+	}))
+	if err := c.AWSAuth("web"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if gotPath != "/v1/auth/aws/login" || c.token != "awstoken" {
+		t.Fatalf("bad login: %v: %v", gotPath, c.token)
+	}
+	if payload["role"] != "web" || payload["iam_http_request_method"] != "POST" {
+		t.Fatalf("bad payload: %v", payload)
+	}
+	decode := func(k string) string {
+		b, err := base64.StdEncoding.DecodeString(payload[k])
+		if err != nil {
+			t.Fatalf("error decoding %v: %v", k, err)
+		}
+		return string(b)
+	}
+	if u := decode("iam_request_url"); u != "https://sts.us-west-2.amazonaws.com" {
+		t.Fatalf("bad url: %v", u)
+	}
+	if b := decode("iam_request_body"); b != awsGetCallerIdentityBody {
+		t.Fatalf("bad body: %v", b)
+	}
+	headers := http.Header{}
+	if err := json.Unmarshal([]byte(decode("iam_request_headers")), &headers); err != nil {
+		t.Fatalf("error unmarshaling headers: %v", err)
+	}
+	if headers.Get("X-Vault-AWS-IAM-Server-ID") != "vault.example.com" || headers.Get("X-Amz-Security-Token") != "session" {
+		t.Fatalf("bad headers: %v", headers)
+	}
+	auth := headers.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=ASIAEXAMPLE/") || !strings.Contains(auth, "/us-west-2/sts/aws4_request") ||
+		!strings.Contains(auth, "x-vault-aws-iam-server-id") {
+		t.Fatalf("bad authorization header: %v", auth)
+	}
+}
+
+func TestVaultClientAWSRelogin(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDFIRST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	var auths []string
+	c := newTestVaultClient(t, &vaultBackend{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/aws/login":
+			var payload map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("error decoding request: %v", err)
+			}
+			b, _ := base64.StdEncoding.DecodeString(payload["iam_request_headers"])
+			headers := http.Header{}
+			if err := json.Unmarshal(b, &headers); err != nil {
+				t.Errorf("error unmarshaling headers: %v", err)
+			}
+			auths = append(auths, headers.Get("Authorization"))
+			writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": fmt.Sprintf("awstoken-%v", len(auths))}})
+		case "/v1/secret/foo":
+			// the first token has expired
+			if r.Header.Get("X-Vault-Token") != "awstoken-2" {
+				w.WriteHeader(http.StatusForbidden)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "bar"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	if err := c.AWSAuth("web"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	// the credentials rotate, and logging in again signs a new request with them
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDROTATED")
+	v, err := c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "bar" || len(auths) != 2 {
+		t.Fatalf("bad value: %v (logins: %v)", string(v), len(auths))
+	}
+	if !strings.Contains(auths[0], "Credential=AKIDFIRST/") || !strings.Contains(auths[1], "Credential=AKIDROTATED/") {
+		t.Fatalf("login requests were not signed again: %v", auths)
+	}
+}
+
+func TestAWSCredentialsFromEnvironment(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := awsCredentialsFromEnvironment(); err == nil {
+		t.Fatalf("expected error for incomplete credentials")
+	}
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	creds, err := awsCredentialsFromEnvironment()
+	if err != nil {
+		t.Fatalf("error getting credentials: %v", err)
+	}
+	if creds.AccessKeyID != "AKIDEXAMPLE" || creds.SecretAccessKey != "secret" {
+		t.Fatalf("bad credentials: %+v", creds)
+	}
+}
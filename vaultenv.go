@@ -0,0 +1,128 @@
+package pvc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables read by WithVaultFromEnvironment, as honored by the Vault CLI
+const (
+	VaultAddrEnvVar          = "VAULT_ADDR"
+	VaultAgentAddrEnvVar     = "VAULT_AGENT_ADDR"
+	VaultTokenEnvVar         = "VAULT_TOKEN"
+	VaultCACertEnvVar        = "VAULT_CACERT"
+	VaultClientCertEnvVar    = "VAULT_CLIENT_CERT"
+	VaultClientKeyEnvVar     = "VAULT_CLIENT_KEY"
+	VaultTLSServerNameEnvVar = "VAULT_TLS_SERVER_NAME"
+	VaultSkipVerifyEnvVar    = "VAULT_SKIP_VERIFY"
+	VaultNamespaceEnvVar     = "VAULT_NAMESPACE"
+	VaultClientTimeoutEnvVar = "VAULT_CLIENT_TIMEOUT"
+)
+
+// VaultTokenHelperFile is the file in the user's home directory where the Vault CLI stores the token after "vault login"
+const VaultTokenHelperFile = ".vault-token"
+
+// applyEnvironment fills any Vault settings that weren't set explicitly from the environment. The token is read from
+// VAULT_TOKEN, or the token helper file if unset, and implies token authentication if no authentication type was set.
+// VAULT_AGENT_ADDR takes precedence over VAULT_ADDR, and without a token implies authentication by the agent.
+func (vb *vaultBackend) applyEnvironment() error {
+	var agent bool
+	if vb.host == "" && len(vb.hosts) == 0 {
+		vb.host = os.Getenv(VaultAgentAddrEnvVar)
+		agent = vb.host != ""
+		if !agent {
+			vb.host = os.Getenv(VaultAddrEnvVar)
+		}
+	}
+	if vb.token == "" {
+		vb.token = os.Getenv(VaultTokenEnvVar)
+	}
+	if vb.token == "" {
+		token, err := readVaultTokenHelper()
+		if err != nil {
+			return err
+		}
+		vb.token = token
+	}
+	if vb.authentication == UnknownVaultAuth && vb.token != "" {
+		vb.authentication = TokenVaultAuth
+	}
+	if vb.authentication == UnknownVaultAuth && agent {
+		vb.authentication = AgentVaultAuth
+	}
+	if vb.namespace == "" {
+		vb.namespace = os.Getenv(VaultNamespaceEnvVar)
+	}
+	if v := os.Getenv(VaultClientTimeoutEnvVar); v != "" && vb.timeout == 0 {
+		d, err := parseVaultDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", VaultClientTimeoutEnvVar, err)
+		}
+		vb.timeout = d
+	}
+	return vb.applyTLSEnvironment()
+}
+
+// applyTLSEnvironment fills any TLS settings that weren't set explicitly from the environment. VAULT_SKIP_VERIFY is only
+// honored if no TLS configuration was set explicitly.
+func (vb *vaultBackend) applyTLSEnvironment() error {
+	t := VaultTLSConfig{}
+	if vb.tls != nil {
+		t = *vb.tls
+	}
+	set := vb.tls != nil
+	setenv := func(field *string, name string) {
+		if v := os.Getenv(name); v != "" && *field == "" {
+			*field = v
+			set = true
+		}
+	}
+	if len(t.CACertPEM) == 0 {
+		setenv(&t.CACertFile, VaultCACertEnvVar)
+	}
+	if t.ClientCertFile == "" && t.ClientKeyFile == "" {
+		setenv(&t.ClientCertFile, VaultClientCertEnvVar)
+		setenv(&t.ClientKeyFile, VaultClientKeyEnvVar)
+	}
+	setenv(&t.ServerName, VaultTLSServerNameEnvVar)
+	if v := os.Getenv(VaultSkipVerifyEnvVar); v != "" && vb.tls == nil {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", VaultSkipVerifyEnvVar, err)
+		}
+		t.InsecureSkipVerify = skip
+		set = true
+	}
+	if set {
+		vb.tls = &t
+	}
+	return nil
+}
+
+// readVaultTokenHelper returns the token stored by the Vault CLI in the user's home directory, or an empty string if there is none
+func readVaultTokenHelper() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", nil
+	}
+	b, err := os.ReadFile(filepath.Join(home, VaultTokenHelperFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("error reading Vault token helper file: %v", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// parseVaultDuration parses a duration the way the Vault CLI does: an integer number of seconds, or a Go duration string
+func parseVaultDuration(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
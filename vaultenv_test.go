@@ -0,0 +1,166 @@
+package pvc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearVaultEnvironment unsets all environment variables read by WithVaultFromEnvironment for the duration of the test
+// and points the home directory at an empty temporary directory
+func clearVaultEnvironment(t *testing.T) string {
+	for _, name := range []string{
+		VaultAddrEnvVar, VaultAgentAddrEnvVar, VaultTokenEnvVar, VaultCACertEnvVar, VaultClientCertEnvVar, VaultClientKeyEnvVar,
+		VaultTLSServerNameEnvVar, VaultSkipVerifyEnvVar, VaultNamespaceEnvVar, VaultClientTimeoutEnvVar,
+	} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	return home
+}
+
+// captureVaultBackend replaces the vault client factory with a fake that records the configuration it was called with
+func captureVaultBackend(t *testing.T) **vaultBackend {
+	var captured *vaultBackend
+	getVaultClient = func(vb *vaultBackend) (vaultIO, error) {
+		captured = vb
+		return &fakeVaultIO{}, nil
+	}
+	t.Cleanup(func() { getVaultClient = newVaultClient })
+	return &captured
+}
+
+func TestWithVaultFromEnvironment(t *testing.T) {
+	clearVaultEnvironment(t)
+	t.Setenv(VaultAddrEnvVar, "https://vault.example.com:8200")
+	t.Setenv(VaultTokenEnvVar, "envtoken")
+	t.Setenv(VaultCACertEnvVar, "/etc/vault/ca.pem")
+	t.Setenv(VaultNamespaceEnvVar, "engineering")
+	t.Setenv(VaultClientTimeoutEnvVar, "30")
+	captured := captureVaultBackend(t)
+
+	_, err := NewSecretsClient(WithVaultFromEnvironment())
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	vb := *captured
+	if vb.host != "https://vault.example.com:8200" {
+		t.Fatalf("bad host: %v", vb.host)
+	}
+	if vb.token != "envtoken" || vb.authentication != TokenVaultAuth {
+		t.Fatalf("bad token auth: %v (%v)", vb.token, vb.authentication)
+	}
+	if vb.tls == nil || vb.tls.CACertFile != "/etc/vault/ca.pem" {
+		t.Fatalf("bad tls config: %+v", vb.tls)
+	}
+	if vb.namespace != "engineering" {
+		t.Fatalf("bad namespace: %v", vb.namespace)
+	}
+	if vb.timeout != 30*time.Second {
+		t.Fatalf("bad timeout: %v", vb.timeout)
+	}
+}
+
+func TestWithVaultFromEnvironmentExplicitPrecedence(t *testing.T) {
+	clearVaultEnvironment(t)
+	t.Setenv(VaultAddrEnvVar, "https://vault.example.com:8200")
+	t.Setenv(VaultTokenEnvVar, "envtoken")
+	t.Setenv(VaultCACertEnvVar, "/etc/vault/ca.pem")
+	t.Setenv(VaultSkipVerifyEnvVar, "true")
+	t.Setenv(VaultNamespaceEnvVar, "engineering")
+	t.Setenv(VaultClientTimeoutEnvVar, "30s")
+	captured := captureVaultBackend(t)
+
+	_, err := NewSecretsClient(
+		WithVaultBackend(K8sVaultAuth, "https://other.example.com:8200"),
+		WithVaultFromEnvironment(),
+		WithVaultNamespace("finance"),
+		WithVaultTLS(VaultTLSConfig{CACertPEM: []byte("ca")}),
+		WithVaultTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	vb := *captured
+	if vb.host != "https://other.example.com:8200" {
+		t.Fatalf("bad host: %v", vb.host)
+	}
+	if vb.authentication != K8sVaultAuth {
+		t.Fatalf("bad authentication: %v", vb.authentication)
+	}
+	if vb.tls.CACertFile != "" || vb.tls.InsecureSkipVerify {
+		t.Fatalf("bad tls config: %+v", vb.tls)
+	}
+	if vb.namespace != "finance" {
+		t.Fatalf("bad namespace: %v", vb.namespace)
+	}
+	if vb.timeout != time.Second {
+		t.Fatalf("bad timeout: %v", vb.timeout)
+	}
+}
+
+func TestWithVaultFromEnvironmentTokenHelper(t *testing.T) {
+	home := clearVaultEnvironment(t)
+	t.Setenv(VaultAddrEnvVar, "https://vault.example.com:8200")
+	if err := os.WriteFile(filepath.Join(home, VaultTokenHelperFile), []byte("helpertoken\n"), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	captured := captureVaultBackend(t)
+
+	_, err := NewSecretsClient(WithVaultFromEnvironment())
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if (*captured).token != "helpertoken" {
+		t.Fatalf("bad token: %v", (*captured).token)
+	}
+}
+
+func TestWithVaultFromEnvironmentErrors(t *testing.T) {
+	clearVaultEnvironment(t)
+	captureVaultBackend(t)
+	_, err := NewSecretsClient(WithVaultFromEnvironment())
+	if err == nil || !strings.Contains(err.Error(), "authentication") {
+		t.Fatalf("expected missing authentication error, received: %v", err)
+	}
+
+	t.Setenv(VaultTokenEnvVar, "envtoken")
+	t.Setenv(VaultClientTimeoutEnvVar, "soon")
+	_, err = NewSecretsClient(WithVaultFromEnvironment())
+	if err == nil || !strings.Contains(err.Error(), VaultClientTimeoutEnvVar) {
+		t.Fatalf("expected invalid timeout error, received: %v", err)
+	}
+
+	t.Setenv(VaultClientTimeoutEnvVar, "")
+	_, err = NewSecretsClient(WithVaultFromEnvironment(), WithEnvVarBackend())
+	if err == nil || !strings.Contains(err.Error(), "exactly one") {
+		t.Fatalf("expected multiple backends error, received: %v", err)
+	}
+}
+
+func TestWithVaultFromEnvironmentAgent(t *testing.T) {
+	clearVaultEnvironment(t)
+	t.Setenv(VaultAddrEnvVar, "https://vault.example.com:8200")
+	t.Setenv(VaultAgentAddrEnvVar, "unix:///var/run/vault-agent.sock")
+	captured := captureVaultBackend(t)
+
+	if _, err := NewSecretsClient(WithVaultFromEnvironment()); err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	vb := *captured
+	if vb.host != "unix:///var/run/vault-agent.sock" || vb.authentication != AgentVaultAuth {
+		t.Fatalf("bad agent config: %v (%v)", vb.host, vb.authentication)
+	}
+
+	// a token selects token authentication, sent through the agent
+	t.Setenv(VaultTokenEnvVar, "envtoken")
+	if _, err := NewSecretsClient(WithVaultFromEnvironment()); err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if vb := *captured; vb.authentication != TokenVaultAuth {
+		t.Fatalf("bad authentication: %v", vb.authentication)
+	}
+}
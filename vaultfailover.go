@@ -0,0 +1,123 @@
+package pvc
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// vaultFailbackInterval is the minimum interval between checks whether a more preferred cluster is healthy again
+var vaultFailbackInterval = 30 * time.Second
+
+// vaultHealthCheckTimeout bounds health checks, which requests failing over to another cluster wait for
+var vaultHealthCheckTimeout = 5 * time.Second
+
+// addresses returns the Vault addresses in order of preference
+func (vb *vaultBackend) addresses() []string {
+	if len(vb.hosts) > 0 {
+		return vb.hosts
+	}
+	if vb.host == "" {
+		return nil
+	}
+	return []string{vb.host}
+}
+
+// api returns the client for the current cluster
+func (c *vaultClient) api() *api.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// vaultHealthy reports whether the cluster can serve requests: initialized, unsealed and not a DR secondary (standbys
+// forward requests to the active node)
+func vaultHealthy(client *api.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), vaultHealthCheckTimeout)
+	defer cancel()
+	h, err := client.Sys().HealthWithContext(ctx)
+	if err != nil {
+		return false
+	}
+	return h.Initialized && !h.Sealed && h.ReplicationDRMode != "secondary"
+}
+
+// failover makes the most preferred healthy cluster other than failed (nil when the client is created) current, and
+// reports whether a cluster other than failed is current. If no cluster is healthy, the current cluster is kept. Clusters
+// are checked without holding the lock, so that other requests can proceed meanwhile.
+func (c *vaultClient) failover(failed *api.Client) bool {
+	if failed != nil && c.api() != failed {
+		// another request has already failed over
+		return true
+	}
+	for i, client := range c.clients {
+		if client == failed || !vaultHealthy(client) {
+			continue
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if failed != nil && c.client != failed {
+			// another request failed over while the clusters were checked
+			return true
+		}
+		if failed != nil {
+			loggerOrDefault(c.config.logger).Warn("Vault failed, failing over", "from", failed.Address(), "to", client.Address())
+		}
+		c.client = client
+		c.current = i
+		c.checked = time.Now()
+		return true
+	}
+	return false
+}
+
+// failback starts checking in the background whether a more preferred cluster is healthy again, at most every
+// vaultFailbackInterval and one check at a time, so that requests don't wait for health checks
+func (c *vaultClient) failback() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == 0 || c.probing || time.Since(c.checked) < vaultFailbackInterval {
+		return
+	}
+	c.checked = time.Now()
+	c.probing = true
+	go c.probe(c.clients[:c.current])
+}
+
+// probe makes the most preferred healthy cluster of preferred current, unless the client has meanwhile failed over to
+// a more preferred cluster
+func (c *vaultClient) probe(preferred []*api.Client) {
+	healthy := -1
+	for i, client := range preferred {
+		if vaultHealthy(client) {
+			healthy = i
+			break
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if healthy < 0 || healthy >= c.current {
+		return
+	}
+	loggerOrDefault(c.config.logger).Info("Vault is healthy again, failing back",
+		"from", c.client.Address(), "to", preferred[healthy].Address())
+	c.client = preferred[healthy]
+	c.current = healthy
+}
+
+// withFailover calls f, a request to Vault, and if it fails with a transient error, fails over to another healthy cluster
+// and repeats it there if repeat is set. If the token isn't valid on that cluster, withRelogin logs in again.
+func (c *vaultClient) withFailover(retryable func(error) bool, repeat bool, f func() error) error {
+	if len(c.clients) < 2 {
+		return f()
+	}
+	c.failback()
+	client := c.api()
+	err := f()
+	if err == nil || !retryable(err) || !c.failover(client) || !repeat {
+		return err
+	}
+	return f()
+}
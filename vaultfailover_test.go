@@ -0,0 +1,173 @@
+package pvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testVaultCluster is a fake Vault cluster that can be taken down, and accepts only tokens it issued
+type testVaultCluster struct {
+	t           *testing.T
+	mu          sync.Mutex
+	down        bool
+	healthDelay time.Duration // delay before responding to health checks
+	token       string
+	value       string
+	srv         *httptest.Server
+}
+
+func newTestVaultCluster(t *testing.T, token, value string) *testVaultCluster {
+	tc := &testVaultCluster{t: t, token: token, value: value}
+	tc.srv = httptest.NewServer(http.HandlerFunc(tc.serve))
+	t.Cleanup(tc.srv.Close)
+	return tc
+}
+
+func (tc *testVaultCluster) setDown(down bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.down = down
+}
+
+func (tc *testVaultCluster) setHealthDelay(d time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.healthDelay = d
+}
+
+func (tc *testVaultCluster) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/sys/health" {
+		tc.mu.Lock()
+		d := tc.healthDelay
+		tc.mu.Unlock()
+		time.Sleep(d)
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeVaultJSON(tc.t, w, map[string]interface{}{"errors": []string{"Vault is sealed"}})
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/sys/health":
+		writeVaultJSON(tc.t, w, map[string]interface{}{"initialized": true, "sealed": false})
+	case "/v1/auth/approle/login":
+		writeVaultJSON(tc.t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": tc.token}})
+	case "/v1/secret/foo":
+		if r.Header.Get("X-Vault-Token") != tc.token {
+			w.WriteHeader(http.StatusForbidden)
+			writeVaultJSON(tc.t, w, map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		writeVaultJSON(tc.t, w, map[string]interface{}{"data": map[string]interface{}{"value": tc.value}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultClientFailover(t *testing.T) {
+	primary := newTestVaultCluster(t, "primary-token", "primary")
+	secondary := newTestVaultCluster(t, "secondary-token", "secondary")
+	primary.setDown(true)
+
+	// the healthy secondary is used when the client is created
+	vb := &vaultBackend{hosts: []string{primary.srv.URL, secondary.srv.URL}}
+	vc, err := newVaultClient(vb)
+	if err != nil {
+		t.Fatalf("error creating vault client: %v", err)
+	}
+	c := vc.(*vaultClient)
+	if err := c.AppRoleAuth("role", "secret"); err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	v, err := c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "secondary" {
+		t.Fatalf("bad value: %v", string(v))
+	}
+
+	// the primary recovers, and the client fails back in the background, without delaying requests
+	interval := vaultFailbackInterval
+	vaultFailbackInterval = 0
+	defer func() { vaultFailbackInterval = interval }()
+	primary.setDown(false)
+	primary.setHealthDelay(200 * time.Millisecond)
+	start := time.Now()
+	v, err = c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "secondary" || time.Since(start) >= 200*time.Millisecond {
+		t.Fatalf("request should not wait for the failback check: %v (took %v)", string(v), time.Since(start))
+	}
+	for i := 0; c.api() != c.clients[0]; i++ {
+		if i == 100 {
+			t.Fatalf("client did not fail back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// requests then go to the primary, after logging in there
+	primary.setHealthDelay(0)
+	v, err = c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "primary" || c.token != "primary-token" {
+		t.Fatalf("bad value: %v (token: %v)", string(v), c.token)
+	}
+
+	// the primary fails, and the request is repeated against the secondary; the current cluster can be read while the
+	// secondary is checked
+	vaultFailbackInterval = interval
+	primary.setDown(true)
+	secondary.setHealthDelay(200 * time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		c.api()
+		if d := time.Since(start); d >= 100*time.Millisecond {
+			t.Errorf("health check should not hold the lock: took %v", d)
+		}
+	}()
+	v, err = c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	secondary.setHealthDelay(0)
+	if string(v) != "secondary" || c.token != "secondary-token" {
+		t.Fatalf("bad value: %v (token: %v)", string(v), c.token)
+	}
+
+	// with no healthy cluster, the error is returned
+	secondary.setDown(true)
+	if _, err := c.GetValue("secret/foo"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestVaultBackendAddresses(t *testing.T) {
+	tests := []struct {
+		name string
+		vb   vaultBackend
+		want []string
+	}{
+		{"none", vaultBackend{}, nil},
+		{"host", vaultBackend{host: "https://a"}, []string{"https://a"}},
+		{"addresses", vaultBackend{host: "https://a", hosts: []string{"https://b", "https://c"}}, []string{"https://b", "https://c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.vb.addresses(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("bad addresses: %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package pvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// GCPVaultRole enumerates the types of role supported by the Vault gcp auth method
+type GCPVaultRole int
+
+// Supported gcp auth method role types
+const (
+	GCEVaultRole GCPVaultRole = iota // gce role: logs in with the instance identity token from the metadata server
+	IAMVaultRole                     // iam role: logs in with a JWT signed by the instance service account via the IAM Credentials API
+)
+
+// GCEMetadataHostEnvVar overrides the host (and optionally port) of the GCE metadata server (the same variable honored by
+// the Google Cloud client libraries)
+const GCEMetadataHostEnvVar = "GCE_METADATA_HOST"
+
+const defaultGCEMetadataHost = "metadata.google.internal"
+
+// gcpIAMCredentialsEndpoint is the IAM Credentials API used to sign JWTs for iam roles (overridden in tests)
+var gcpIAMCredentialsEndpoint = "https://iamcredentials.googleapis.com"
+
+// GCPAuth logs in with the gcp auth method as the default service account of the GCE instance. A new JWT is obtained for
+// each login, since JWTs expire.
+func (c *vaultClient) GCPAuth(role string) error {
+	if role == "" {
+		return fmt.Errorf("role is required")
+	}
+	var getJWT func() (string, error)
+	switch c.config.gcproletype {
+	case GCEVaultRole:
+		getJWT = func() (string, error) { return gceIdentityToken(fmt.Sprintf("http://vault/%v", role)) }
+	case IAMVaultRole:
+		getJWT = func() (string, error) { return gcpSignedJWT(fmt.Sprintf("vault/%v", role)) }
+	default:
+		return fmt.Errorf("unknown gcp role type: %v", c.config.gcproletype)
+	}
+	return c.login(fmt.Sprintf("/v1/auth/%v/login", authPath(c.config.gcpauthpath, "gcp")), func() (interface{}, error) {
+		jwt, err := getJWT()
+		if err != nil {
+			return nil, err
+		}
+		return &struct {
+			JWT  string `json:"jwt"`
+			Role string `json:"role"`
+		}{
+			JWT:  jwt,
+			Role: role,
+		}, nil
+	})
+}
+
+// gceMetadata returns the value at path on the GCE metadata server
+func gceMetadata(hc *http.Client, path string) ([]byte, error) {
+	host := os.Getenv(GCEMetadataHostEnvVar)
+	if host == "" {
+		host = defaultGCEMetadataHost
+	}
+	req, err := http.NewRequest("GET", "http://"+host+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return metadataRequest(hc, req)
+}
+
+// gceIdentityToken returns an instance identity token for audience from the GCE metadata server
+func gceIdentityToken(audience string) (string, error) {
+	hc := &http.Client{Timeout: cloudMetadataTimeout}
+	v := url.Values{"audience": {audience}, "format": {"full"}}
+	token, err := gceMetadata(hc, "instance/service-accounts/default/identity?"+v.Encode())
+	if err != nil {
+		return "", fmt.Errorf("error getting instance identity token: %v", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// gcpSignedJWT returns a JWT for audience signed by the instance service account with the IAM Credentials API
+func gcpSignedJWT(audience string) (string, error) {
+	hc := &http.Client{Timeout: cloudMetadataTimeout}
+	email, err := gceMetadata(hc, "instance/service-accounts/default/email")
+	if err != nil {
+		return "", fmt.Errorf("error getting service account email: %v", err)
+	}
+	tb, err := gceMetadata(hc, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", fmt.Errorf("error getting service account access token: %v", err)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.Unmarshal(tb, &token); err != nil {
+		return "", fmt.Errorf("error unmarshaling service account access token: %v", err)
+	}
+	sa := strings.TrimSpace(string(email))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"sub": sa,
+		"exp": time.Now().Add(15 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{"payload": string(claims)})
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%v/v1/projects/-/serviceAccounts/%v:signJwt", strings.TrimSuffix(gcpIAMCredentialsEndpoint, "/"), url.PathEscape(sa))
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	sb, err := metadataRequest(hc, req)
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT: %v", err)
+	}
+	signed := struct {
+		SignedJWT string `json:"signedJwt"`
+	}{}
+	if err := json.Unmarshal(sb, &signed); err != nil {
+		return "", fmt.Errorf("error unmarshaling signed JWT: %v", err)
+	}
+	if signed.SignedJWT == "" {
+		return "", fmt.Errorf("empty signed JWT")
+	}
+	return signed.SignedJWT, nil
+}
@@ -0,0 +1,148 @@
+package pvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGCPServer serves the GCE metadata server and IAM Credentials API endpoints used for GCP authentication
+func fakeGCPServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	issued := map[string]int{} // JWTs issued by endpoint, numbered so that each is distinct
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/computeMetadata/") && r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/identity":
+			if r.URL.Query().Get("audience") != "http://vault/web" || r.URL.Query().Get("format") != "full" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			issued[r.URL.Path]++
+			fmt.Fprintf(w, "gce.identity.jwt.%v", issued[r.URL.Path])
+		case "/computeMetadata/v1/instance/service-accounts/default/email":
+			w.Write([]byte("web@project.iam.gserviceaccount.com"))
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			w.Write([]byte(`{"access_token": "accesstoken", "expires_in": 3599, "token_type": "Bearer"}`))
+		case "/v1/projects/-/serviceAccounts/web@project.iam.gserviceaccount.com:signJwt":
+			var body struct {
+				Payload string `json:"payload"`
+			}
+			var claims map[string]interface{}
+			if r.Header.Get("Authorization") != "Bearer accesstoken" ||
+				json.NewDecoder(r.Body).Decode(&body) != nil || json.Unmarshal([]byte(body.Payload), &claims) != nil ||
+				claims["aud"] != "vault/web" || claims["sub"] != "web@project.iam.gserviceaccount.com" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			issued[r.URL.Path]++
+			writeVaultJSON(t, w, map[string]string{"keyId": "1", "signedJwt": fmt.Sprintf("iam.signed.jwt.%v", issued[r.URL.Path])})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultClientGCPAuth(t *testing.T) {
+	srv := fakeGCPServer(t)
+	t.Setenv(GCEMetadataHostEnvVar, strings.TrimPrefix(srv.URL, "http://"))
+	orig := gcpIAMCredentialsEndpoint
+	gcpIAMCredentialsEndpoint = srv.URL
+	defer func() { gcpIAMCredentialsEndpoint = orig }()
+
+	tests := []struct {
+		name     string
+		vb       *vaultBackend
+		wantPath string
+		wantJWT  string
+	}{
+		{"gce", &vaultBackend{gcproletype: GCEVaultRole}, "/v1/auth/gcp/login", "gce.identity.jwt.1"},
+		{"iam", &vaultBackend{gcproletype: IAMVaultRole, gcpauthpath: "gcp-prod"}, "/v1/auth/gcp-prod/login", "iam.signed.jwt.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			var payload map[string]string
+			c := newTestVaultClient(t, tt.vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+					t.Errorf("error decoding request: %v", err)
+				}
+				writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "gcptoken"}})
+			}))
+			if err := c.GCPAuth("web"); err != nil {
+				t.Fatalf("login failed: %v", err)
+			}
+			if gotPath != tt.wantPath {
+				t.Fatalf("bad path: %v (wanted %v)", gotPath, tt.wantPath)
+			}
+			if payload["jwt"] != tt.wantJWT || payload["role"] != "web" {
+				t.Fatalf("bad payload: %v", payload)
+			}
+			if c.token != "gcptoken" {
+				t.Fatalf("bad token: %v", c.token)
+			}
+		})
+	}
+}
+
+func TestVaultClientGCPRelogin(t *testing.T) {
+	srv := fakeGCPServer(t)
+	t.Setenv(GCEMetadataHostEnvVar, strings.TrimPrefix(srv.URL, "http://"))
+	var jwts []string
+	c := newTestVaultClient(t, &vaultBackend{gcproletype: GCEVaultRole}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/gcp/login":
+			var payload map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("error decoding request: %v", err)
+			}
+			jwts = append(jwts, payload["jwt"])
+			writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": fmt.Sprintf("gcptoken-%v", len(jwts))}})
+		case "/v1/secret/foo":
+			// the first token has expired
+			if r.Header.Get("X-Vault-Token") != "gcptoken-2" {
+				w.WriteHeader(http.StatusForbidden)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "bar"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	if err := c.GCPAuth("web"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	v, err := c.GetValue("secret/foo")
+	if err != nil {
+		t.Fatalf("error getting value: %v", err)
+	}
+	if string(v) != "bar" || !reflect.DeepEqual(jwts, []string{"gce.identity.jwt.1", "gce.identity.jwt.2"}) {
+		t.Fatalf("logging in again should use a new identity token: %v (JWTs: %v)", string(v), jwts)
+	}
+}
+
+func TestVaultClientGCPAuthErrors(t *testing.T) {
+	srv := fakeGCPServer(t)
+	t.Setenv(GCEMetadataHostEnvVar, strings.TrimPrefix(srv.URL, "http://"))
+	c := newTestVaultClient(t, &vaultBackend{}, http.NotFoundHandler())
+	if err := c.GCPAuth(""); err == nil {
+		t.Fatalf("expected error for missing role")
+	}
+	if err := c.GCPAuth("other"); err == nil || !strings.Contains(err.Error(), "identity token") {
+		t.Fatalf("expected identity token error, received: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package pvc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// leaseRenewAfter returns how long to wait before renewing a lease of duration d (overridden in tests)
+var leaseRenewAfter = func(d time.Duration) time.Duration {
+	return d * 2 / 3
+}
+
+// leaseMinRenewInterval is the minimum interval between renewals of a lease, so that short leases aren't renewed in a
+// tight loop
+var leaseMinRenewInterval = time.Second
+
+// vaultLease tracks the renewal of a dynamic secret lease
+type vaultLease struct {
+	secret   *DynamicSecret
+	location string
+	stop     chan struct{}
+	done     chan struct{} // closed when renewal has stopped
+	stopOnce sync.Once
+}
+
+// GetDynamic reads a leased secret and starts renewing its lease in the background
+func (vbg *vaultBackendGetter) GetDynamic(id string) (*DynamicSecret, error) {
+	location, err := vbg.mapper.MapSecret(id)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping id to path: %v", err)
+	}
+	s, err := vbg.vc.ReadDynamicSecret(location)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dynamic secret (id: %v): %v", id, err)
+	}
+	if s.LeaseID == "" {
+		return nil, fmt.Errorf("secret is not leased (id: %v): use Get for static secrets", id)
+	}
+	ds := &DynamicSecret{
+		Data:          make(map[string]string, len(s.Data)),
+		LeaseID:       s.LeaseID,
+		LeaseDuration: time.Duration(s.LeaseDuration) * time.Second,
+		Renewable:     s.Renewable,
+		expiring:      make(chan struct{}),
+	}
+	for k, v := range s.Data {
+		ds.Data[k], err = stringValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding dynamic secret field %v (id: %v): %v", k, id, err)
+		}
+	}
+	l := &vaultLease{secret: ds, location: location, stop: make(chan struct{}), done: make(chan struct{})}
+	ds.revoke = func() error { return vbg.revokeLease(l) }
+	vbg.leaseMu.Lock()
+	defer vbg.leaseMu.Unlock()
+	if vbg.leases == nil {
+		vbg.leases = map[string]*vaultLease{}
+	}
+	vbg.leases[ds.LeaseID] = l
+	go vbg.renewLease(l)
+	return ds, nil
+}
+
+// renewLease renews the lease until it can no longer be renewed or is stopped, notifies the caller shortly before the
+// lease expires, and stops tracking it once it has expired
+func (vbg *vaultBackendGetter) renewLease(l *vaultLease) {
+	defer close(l.done)
+	d := l.secret.LeaseDuration
+	if d <= 0 {
+		// a lease without a duration doesn't expire, so there is nothing to renew
+		return
+	}
+	defer l.secret.notifyExpiring()
+	defer vbg.forgetLease(l)
+	expires := time.Now().Add(d)
+	for l.secret.Renewable {
+		wait := leaseRenewAfter(d)
+		if wait < leaseMinRenewInterval {
+			wait = leaseMinRenewInterval
+		}
+		if !l.sleep(wait) {
+			return
+		}
+		s, err := vbg.vc.RenewLease(l.location, l.secret.LeaseID, l.secret.LeaseDuration)
+		if err != nil {
+			loggerOrDefault(vbg.config.logger).Error("error renewing lease", "lease_id", l.secret.LeaseID, "error", err)
+			d = 0
+			break
+		}
+		d = time.Duration(s.LeaseDuration) * time.Second
+		expires = time.Now().Add(d)
+		if d < l.secret.LeaseDuration {
+			// the lease is capped at its maximum TTL and will expire after d
+			break
+		}
+	}
+	if d > 0 && !l.sleep(leaseRenewAfter(d)) {
+		return
+	}
+	l.secret.notifyExpiring()
+	l.sleep(time.Until(expires))
+}
+
+// sleep waits for d and reports whether the lease was not stopped in the meantime
+func (l *vaultLease) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-l.stop:
+		return false
+	}
+}
+
+// forgetLease stops tracking an expired lease
+func (vbg *vaultBackendGetter) forgetLease(l *vaultLease) {
+	vbg.leaseMu.Lock()
+	defer vbg.leaseMu.Unlock()
+	if vbg.leases[l.secret.LeaseID] == l {
+		delete(vbg.leases, l.secret.LeaseID)
+	}
+}
+
+// revokeLease stops renewing the lease and revokes it
+func (vbg *vaultBackendGetter) revokeLease(l *vaultLease) error {
+	revoke := false
+	l.stopOnce.Do(func() {
+		close(l.stop)
+		revoke = true
+	})
+	if !revoke {
+		return nil
+	}
+	<-l.done
+	vbg.forgetLease(l)
+	if err := vbg.vc.RevokeLease(l.location, l.secret.LeaseID); err != nil {
+		return fmt.Errorf("error revoking lease: %v: %v", l.secret.LeaseID, err)
+	}
+	return nil
+}
+
+// Close stops renewing PKI certificates and revokes all outstanding leases
+func (vbg *vaultBackendGetter) Close() error {
+	vbg.leaseMu.Lock()
+	leases := make([]*vaultLease, 0, len(vbg.leases))
+	for _, l := range vbg.leases {
+		leases = append(leases, l)
+	}
+	certs := vbg.certs
+	vbg.certs = nil
+	vbg.leaseMu.Unlock()
+	for _, pc := range certs {
+		pc.close()
+	}
+	var errs []error
+	for _, l := range leases {
+		if err := vbg.revokeLease(l); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
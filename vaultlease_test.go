@@ -0,0 +1,250 @@
+package pvc
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeaseServer emulates a Vault database secrets engine issuing leased credentials
+type fakeLeaseServer struct {
+	sync.Mutex
+	renewable     bool
+	renewDuration int // lease duration returned by renewals
+	renewals      int
+	revoked       []string
+}
+
+func (f *fakeLeaseServer) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"id": "dummy"}})
+		case "/v1/database/creds/readonly":
+			writeVaultJSON(t, w, map[string]interface{}{
+				"lease_id":       "database/creds/readonly/abc",
+				"lease_duration": 60,
+				"renewable":      f.renewable,
+				"data":           map[string]interface{}{"username": "v-readonly", "password": "pa55w0rd", "ttl": 60},
+			})
+		case "/v1/secret/static":
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"value": "foo"}})
+		case "/v1/sys/leases/renew":
+			f.renewals++
+			writeVaultJSON(t, w, map[string]interface{}{
+				"lease_id":       "database/creds/readonly/abc",
+				"lease_duration": f.renewDuration,
+				"renewable":      true,
+			})
+		case "/v1/sys/leases/revoke":
+			f.revoked = append(f.revoked, "database/creds/readonly/abc")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func newTestLeaseClient(t *testing.T, f *fakeLeaseServer) *SecretsClient {
+	orig, origMin := leaseRenewAfter, leaseMinRenewInterval
+	leaseRenewAfter = func(d time.Duration) time.Duration { return d / 1000 }
+	leaseMinRenewInterval = time.Millisecond
+	t.Cleanup(func() { leaseRenewAfter, leaseMinRenewInterval = orig, origMin })
+	vb := &vaultBackend{authentication: TokenVaultAuth, token: "dummy", mapping: "{{ .ID }}"}
+	c := newTestVaultClient(t, vb, f.handler(t))
+	vbg, err := newVaultBackendGetter(vb, c)
+	if err != nil {
+		t.Fatalf("error creating backend: %v", err)
+	}
+	return &SecretsClient{backend: vbg}
+}
+
+func TestGetDynamic(t *testing.T) {
+	f := &fakeLeaseServer{renewable: true, renewDuration: 60}
+	sc := newTestLeaseClient(t, f)
+	ds, err := sc.GetDynamic("database/creds/readonly")
+	if err != nil {
+		t.Fatalf("error getting dynamic secret: %v", err)
+	}
+	want := map[string]string{"username": "v-readonly", "password": "pa55w0rd", "ttl": "60"}
+	if !reflect.DeepEqual(ds.Data, want) {
+		t.Fatalf("bad data: %v", ds.Data)
+	}
+	if ds.LeaseID != "database/creds/readonly/abc" || ds.LeaseDuration != time.Minute || !ds.Renewable {
+		t.Fatalf("bad lease: %+v", ds)
+	}
+	// renewed every 60ms
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-ds.Expiring():
+		t.Fatalf("lease should not be expiring")
+	default:
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.renewals == 0 {
+		t.Fatalf("lease was not renewed")
+	}
+	if len(f.revoked) != 1 {
+		t.Fatalf("lease was not revoked: %v", f.revoked)
+	}
+}
+
+func TestGetDynamicExpiring(t *testing.T) {
+	tests := []struct {
+		name     string
+		f        *fakeLeaseServer
+		renewals int
+	}{
+		{"not renewable", &fakeLeaseServer{renewable: false}, 0},
+		{"max ttl", &fakeLeaseServer{renewable: true, renewDuration: 30}, 1},
+		{"expired", &fakeLeaseServer{renewable: true, renewDuration: 0}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newTestLeaseClient(t, tt.f)
+			defer sc.Close()
+			ds, err := sc.GetDynamic("database/creds/readonly")
+			if err != nil {
+				t.Fatalf("error getting dynamic secret: %v", err)
+			}
+			select {
+			case <-ds.Expiring():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for lease expiry notification")
+			}
+			tt.f.Lock()
+			defer tt.f.Unlock()
+			if tt.f.renewals != tt.renewals {
+				t.Fatalf("bad renewals: %v (wanted %v)", tt.f.renewals, tt.renewals)
+			}
+		})
+	}
+}
+
+func TestGetDynamicExpiringDelayed(t *testing.T) {
+	f := &fakeLeaseServer{renewable: true, renewDuration: 30}
+	sc := newTestLeaseClient(t, f)
+	leaseRenewAfter = func(d time.Duration) time.Duration {
+		if d < time.Minute {
+			// capped at its maximum TTL
+			return time.Hour
+		}
+		return d / 1000
+	}
+	ds, err := sc.GetDynamic("database/creds/readonly")
+	if err != nil {
+		t.Fatalf("error getting dynamic secret: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-ds.Expiring():
+		t.Fatalf("lease should not be expiring until shortly before its maximum TTL")
+	default:
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+	<-ds.Expiring()
+	f.Lock()
+	defer f.Unlock()
+	if f.renewals != 1 || len(f.revoked) != 1 {
+		t.Fatalf("bad renewals or revocations: %v, %v", f.renewals, f.revoked)
+	}
+}
+
+func TestGetDynamicExpired(t *testing.T) {
+	f := &fakeLeaseServer{renewable: true, renewDuration: 0}
+	sc := newTestLeaseClient(t, f)
+	ds, err := sc.GetDynamic("database/creds/readonly")
+	if err != nil {
+		t.Fatalf("error getting dynamic secret: %v", err)
+	}
+	<-ds.Expiring()
+	vbg := sc.backend.(*vaultBackendGetter)
+	for i := 0; ; i++ {
+		vbg.leaseMu.Lock()
+		n := len(vbg.leases)
+		vbg.leaseMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("expired lease is still tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if len(f.revoked) != 0 {
+		t.Fatalf("expired lease should not be revoked: %v", f.revoked)
+	}
+}
+
+func TestGetDynamicMinRenewInterval(t *testing.T) {
+	f := &fakeLeaseServer{renewable: true, renewDuration: 60}
+	sc := newTestLeaseClient(t, f)
+	leaseRenewAfter = func(time.Duration) time.Duration { return 0 }
+	leaseMinRenewInterval = 50 * time.Millisecond
+	if _, err := sc.GetDynamic("database/creds/readonly"); err != nil {
+		t.Fatalf("error getting dynamic secret: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := sc.Close(); err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.renewals == 0 || f.renewals > 5 {
+		t.Fatalf("bad renewals: %v", f.renewals)
+	}
+}
+
+func TestDynamicSecretRevoke(t *testing.T) {
+	f := &fakeLeaseServer{renewable: true, renewDuration: 60}
+	sc := newTestLeaseClient(t, f)
+	ds, err := sc.GetDynamic("database/creds/readonly")
+	if err != nil {
+		t.Fatalf("error getting dynamic secret: %v", err)
+	}
+	if err := ds.Revoke(); err != nil {
+		t.Fatalf("error revoking: %v", err)
+	}
+	<-ds.Expiring()
+	if err := sc.Close(); err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if len(f.revoked) != 1 {
+		t.Fatalf("lease should be revoked once: %v", f.revoked)
+	}
+}
+
+func TestGetDynamicErrors(t *testing.T) {
+	sc := newTestLeaseClient(t, &fakeLeaseServer{})
+	if _, err := sc.GetDynamic("secret/static"); err == nil || !strings.Contains(err.Error(), "not leased") {
+		t.Fatalf("expected not leased error, received: %v", err)
+	}
+	sc, err := NewSecretsClient(WithEnvVarBackend())
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if _, err := sc.GetDynamic("foo"); err == nil {
+		t.Fatalf("expected error for backend without dynamic secrets")
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package pvc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// DefaultVaultPKIMount is the default mount path of the Vault PKI engine
+const DefaultVaultPKIMount = "pki"
+
+// CertificateRequest describes a certificate to issue from the Vault PKI engine. The PKI role determines which names
+// are allowed.
+type CertificateRequest struct {
+	CommonName  string
+	DNSNames    []string      // additional DNS subject alternative names
+	IPAddresses []string      // IP subject alternative names
+	URIs        []string      // URI subject alternative names (eg, SPIFFE IDs)
+	TTL         time.Duration // requested lifetime (default: the role TTL)
+}
+
+// data returns the request parameters for the pki/issue endpoint
+func (r CertificateRequest) data() map[string]interface{} {
+	d := map[string]interface{}{"common_name": r.CommonName}
+	if len(r.DNSNames) > 0 {
+		d["alt_names"] = strings.Join(r.DNSNames, ",")
+	}
+	if len(r.IPAddresses) > 0 {
+		d["ip_sans"] = strings.Join(r.IPAddresses, ",")
+	}
+	if len(r.URIs) > 0 {
+		d["uri_sans"] = strings.Join(r.URIs, ",")
+	}
+	if r.TTL > 0 {
+		d["ttl"] = r.TTL.String()
+	}
+	return d
+}
+
+// certRenewAt returns when to re-issue a certificate valid from notBefore until notAfter (overridden in tests)
+var certRenewAt = func(notBefore, notAfter time.Time) time.Time {
+	return notBefore.Add(notAfter.Sub(notBefore) * 2 / 3)
+}
+
+// certRetryInterval is the interval between attempts to re-issue a certificate after an error
+var certRetryInterval = time.Minute
+
+// pkiCertificate issues certificates from a PKI role and re-issues them in the background before they expire
+type pkiCertificate struct {
+	mu      sync.RWMutex
+	vc      vaultIO
+	path    string
+	req     CertificateRequest
+	cert    *tls.Certificate
+	cas     *x509.CertPool // issuing CA chain of cert
+	renewAt time.Time
+	err     error // last error re-issuing the certificate
+	logger  Logger
+	stop    chan struct{}
+	done    chan struct{} // closed when renewal has stopped
+}
+
+// PKITLSConfig issues a certificate from the Vault PKI role at "<mount>/issue/<role>" (mount defaults to
+// DefaultVaultPKIMount if empty) and returns a TLS configuration that presents it as both the server and client
+// certificate. The certificate is re-issued in the background when two thirds of its lifetime have elapsed; if
+// re-issuing fails, the current certificate is used until it expires. Peers are verified against the issuing CA chain of
+// the current certificate, so mutual TLS between services using the same PKI mount only requires setting ClientAuth on
+// the returned configuration. Servers get the chain from GetConfigForClient; clients verify it in VerifyConnection, with
+// InsecureSkipVerify disabling the default verification against RootCAs, which (like ClientCAs) only contains the chain
+// of the first certificate. As with the default verification, clients must set ServerName (tls.Dial sets it from the
+// address). The client must use the Vault backend; Close stops renewal.
+func (sc *SecretsClient) PKITLSConfig(mount, role string, req CertificateRequest) (*tls.Config, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	vbg, ok := be.(*vaultBackendGetter)
+	if !ok {
+		return nil, fmt.Errorf("PKI certificates require the Vault backend")
+	}
+	if _, err := validatePathID(role); err != nil || strings.Contains(role, "/") {
+		return nil, fmt.Errorf("invalid PKI role name: %q", role)
+	}
+	if req.CommonName == "" {
+		return nil, fmt.Errorf("common name is required")
+	}
+	pc := &pkiCertificate{
+		vc:     vbg.vc,
+		path:   fmt.Sprintf("%v/issue/%v", authPath(mount, DefaultVaultPKIMount), role),
+		req:    req,
+		logger: loggerOrDefault(sc.logger),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := pc.reissue(); err != nil {
+		return nil, err
+	}
+	vbg.leaseMu.Lock()
+	vbg.certs = append(vbg.certs, pc)
+	vbg.leaseMu.Unlock()
+	go pc.renew()
+	_, cas, _ := pc.current()
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    cas,
+		ClientCAs:  cas,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _, err := pc.current()
+			return cert, err
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _, err := pc.current()
+			return cert, err
+		},
+		InsecureSkipVerify: true,
+		VerifyConnection:   pc.verifyServer,
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		_, cas, err := pc.current()
+		if err != nil {
+			return nil, err
+		}
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = cas
+		c.InsecureSkipVerify = false
+		c.VerifyConnection = nil
+		return c, nil
+	}
+	return config, nil
+}
+
+// current returns the current certificate and its issuing CA chain, or an error if it has expired
+func (pc *pkiCertificate) current() (*tls.Certificate, *x509.CertPool, error) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	if !time.Now().Before(pc.cert.Leaf.NotAfter) {
+		return nil, nil, fmt.Errorf("certificate expired at %v: %v", pc.cert.Leaf.NotAfter, pc.err)
+	}
+	return pc.cert, pc.cas, nil
+}
+
+// verifyServer verifies the server certificate chain against the current issuing CA chain when connecting as a client
+func (pc *pkiCertificate) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server did not present a certificate")
+	}
+	if cs.ServerName == "" {
+		// crypto/tls requires ServerName unless InsecureSkipVerify is set, which is only set to verify here instead
+		return fmt.Errorf("ServerName must be specified in the tls.Config to verify the server certificate")
+	}
+	_, cas, err := pc.current()
+	if err != nil {
+		return err
+	}
+	opts := x509.VerifyOptions{
+		Roots:         cas,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// renew re-issues the certificate when it is due for renewal until stopped, retrying every certRetryInterval on errors
+func (pc *pkiCertificate) renew() {
+	defer close(pc.done)
+	for {
+		pc.mu.RLock()
+		renewAt := pc.renewAt
+		pc.mu.RUnlock()
+		t := time.NewTimer(time.Until(renewAt))
+		select {
+		case <-t.C:
+		case <-pc.stop:
+			t.Stop()
+			return
+		}
+		if err := pc.reissue(); err != nil {
+			pc.mu.Lock()
+			pc.err = err
+			pc.renewAt = time.Now().Add(certRetryInterval)
+			expires := pc.cert.Leaf.NotAfter
+			pc.mu.Unlock()
+			pc.logger.Warn("error renewing certificate, using current certificate until it expires",
+				"path", pc.path, "expires", expires, "error", err)
+		}
+	}
+}
+
+// reissue issues a new certificate and makes it current
+func (pc *pkiCertificate) reissue() error {
+	cert, cas, err := pc.issue()
+	if err != nil {
+		return err
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.cert = cert
+	pc.cas = cas
+	pc.renewAt = certRenewAt(cert.Leaf.NotBefore, cert.Leaf.NotAfter)
+	pc.err = nil
+	return nil
+}
+
+// issue issues a new certificate and returns it with a pool containing the issuing CA chain
+func (pc *pkiCertificate) issue() (*tls.Certificate, *x509.CertPool, error) {
+	s, err := pc.vc.WriteSecret(pc.path, pc.req.data())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error issuing certificate: %v", err)
+	}
+	field := func(k string) string {
+		v, _ := s.Data[k].(string)
+		return v
+	}
+	cas := x509.NewCertPool()
+	cas.AppendCertsFromPEM([]byte(field("issuing_ca")))
+	if chain, ok := s.Data["ca_chain"].([]interface{}); ok {
+		for _, c := range chain {
+			if c, ok := c.(string); ok {
+				cas.AppendCertsFromPEM([]byte(c))
+			}
+		}
+	}
+	chain := field("certificate") + "\n" + field("issuing_ca")
+	cert, err := tls.X509KeyPair([]byte(chain), []byte(field("private_key")))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading issued certificate: %v", err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing issued certificate: %v", err)
+	}
+	return &cert, cas, nil
+}
+
+// close stops renewing the certificate
+func (pc *pkiCertificate) close() {
+	close(pc.stop)
+	<-pc.done
+}
+
+// WriteSecret writes data to path and returns the response, which may be nil
+func (c *vaultClient) WriteSecret(path string, data map[string]interface{}) (*api.Secret, error) {
+	var s *api.Secret
+	location := path
+	err := c.doOnce(func() (err error) {
+		var client *api.Client
+		client, path = c.clientFor(location)
+		s, err = client.Logical().Write(path, data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("empty response: %v", path)
+	}
+	return s, nil
+}
@@ -0,0 +1,273 @@
+package pvc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePKIServer emulates a Vault PKI engine issuing certificates signed by a test CA
+type fakePKIServer struct {
+	sync.Mutex
+	caPEM  []byte
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	issued int
+	fail   bool
+}
+
+func newFakePKIServer(t *testing.T) *fakePKIServer {
+	caPEM, caKeyPEM := testCertificate(t, "Test CA")
+	b, _ := pem.Decode(caPEM)
+	ca, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing CA: %v", err)
+	}
+	kb, _ := pem.Decode(caKeyPEM)
+	caKey, err := x509.ParseECPrivateKey(kb.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing CA key: %v", err)
+	}
+	return &fakePKIServer{caPEM: caPEM, ca: ca, caKey: caKey}
+}
+
+func (f *fakePKIServer) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"id": "dummy"}})
+		case "/v1/pki_int/issue/web":
+			if f.fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"internal error"}})
+				return
+			}
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("error decoding request: %v", err)
+			}
+			f.issued++
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Errorf("error generating key: %v", err)
+			}
+			tmpl := &x509.Certificate{
+				SerialNumber: big.NewInt(int64(f.issued + 1)),
+				Subject:      pkix.Name{CommonName: body["common_name"]},
+				DNSNames:     []string{body["common_name"]},
+				NotBefore:    time.Now().Add(-time.Minute),
+				NotAfter:     time.Now().Add(time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			}
+			if body["alt_names"] != "" {
+				tmpl.DNSNames = append(tmpl.DNSNames, strings.Split(body["alt_names"], ",")...)
+			}
+			if ip := net.ParseIP(body["ip_sans"]); ip != nil {
+				tmpl.IPAddresses = []net.IP{ip}
+			}
+			der, err := x509.CreateCertificate(rand.Reader, tmpl, f.ca, &key.PublicKey, f.caKey)
+			if err != nil {
+				t.Errorf("error creating certificate: %v", err)
+			}
+			kder, _ := x509.MarshalECPrivateKey(key)
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{
+				"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+				"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})),
+				"issuing_ca":  string(f.caPEM),
+				"ca_chain":    []string{string(f.caPEM)},
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func newTestPKIClient(t *testing.T, f *fakePKIServer) *SecretsClient {
+	vb := &vaultBackend{authentication: TokenVaultAuth, token: "dummy"}
+	c := newTestVaultClient(t, vb, f.handler(t))
+	vbg, err := newVaultBackendGetter(vb, c)
+	if err != nil {
+		t.Fatalf("error creating backend: %v", err)
+	}
+	return &SecretsClient{backend: vbg}
+}
+
+func TestPKITLSConfig(t *testing.T) {
+	f := newFakePKIServer(t)
+	sc := newTestPKIClient(t, f)
+	defer sc.Close()
+	req := CertificateRequest{CommonName: "web.internal", DNSNames: []string{"web"}, IPAddresses: []string{"127.0.0.1"}, TTL: time.Hour}
+	config, err := sc.PKITLSConfig("pki_int", "web", req)
+	if err != nil {
+		t.Fatalf("error getting TLS config: %v", err)
+	}
+	cert, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("error getting certificate: %v", err)
+	}
+	if cert.Leaf.Subject.CommonName != "web.internal" || len(cert.Certificate) != 2 {
+		t.Fatalf("bad certificate: %v (chain: %v)", cert.Leaf.Subject, len(cert.Certificate))
+	}
+	if len(cert.Leaf.DNSNames) != 2 || cert.Leaf.DNSNames[1] != "web" || len(cert.Leaf.IPAddresses) != 1 {
+		t.Fatalf("bad SANs: %v %v", cert.Leaf.DNSNames, cert.Leaf.IPAddresses)
+	}
+
+	// mutual TLS between two services using the same configuration
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	clientConfig := config.Clone()
+	clientConfig.ServerName = "web.internal"
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	conn.Close()
+	clientConfig.ServerName = "other.internal"
+	if _, err := tls.Dial("tcp", ln.Addr().String(), clientConfig); err == nil {
+		t.Fatalf("expected error for wrong server name")
+	}
+
+	// without ServerName, the server certificate can't be verified
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer raw.Close()
+	clientConfig.ServerName = ""
+	if err := tls.Client(raw, clientConfig).Handshake(); err == nil || !strings.Contains(err.Error(), "ServerName") {
+		t.Fatalf("expected error for missing server name, received: %v", err)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if f.issued != 1 {
+		t.Fatalf("certificate should be issued once: %v", f.issued)
+	}
+}
+
+func TestPKITLSConfigRenewal(t *testing.T) {
+	origRenewAt, origRetry := certRenewAt, certRetryInterval
+	certRenewAt = func(notBefore, notAfter time.Time) time.Time { return time.Now().Add(50 * time.Millisecond) }
+	certRetryInterval = 50 * time.Millisecond
+	defer func() { certRenewAt, certRetryInterval = origRenewAt, origRetry }()
+	f := newFakePKIServer(t)
+	sc := newTestPKIClient(t, f)
+	defer sc.Close()
+	config, err := sc.PKITLSConfig("pki_int", "web", CertificateRequest{CommonName: "web.internal"})
+	if err != nil {
+		t.Fatalf("error getting TLS config: %v", err)
+	}
+	first, err := config.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("error getting certificate: %v", err)
+	}
+
+	// the certificate is re-issued in the background by a rotated CA
+	ca := newFakePKIServer(t)
+	f.Lock()
+	f.caPEM, f.ca, f.caKey = ca.caPEM, ca.ca, ca.caKey
+	f.Unlock()
+	var cert *tls.Certificate
+	for i := 0; ; i++ {
+		if cert, err = config.GetCertificate(nil); err != nil {
+			t.Fatalf("error getting certificate: %v", err)
+		}
+		if cert != first && cert.Leaf.CheckSignatureFrom(ca.ca) == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("certificate was not re-issued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server, err := config.GetConfigForClient(nil)
+	if err != nil {
+		t.Fatalf("error getting server config: %v", err)
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: server.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Fatalf("client CAs should contain the rotated CA: %v", err)
+	}
+	if err := config.VerifyConnection(tls.ConnectionState{ServerName: "web.internal", PeerCertificates: []*x509.Certificate{cert.Leaf}}); err != nil {
+		t.Fatalf("server certificate issued by the rotated CA should be verified: %v", err)
+	}
+	if err := config.VerifyConnection(tls.ConnectionState{ServerName: "web.internal", PeerCertificates: []*x509.Certificate{first.Leaf}}); err == nil {
+		t.Fatalf("expected error for server certificate issued by the previous CA")
+	}
+	if err := config.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}); err == nil {
+		t.Fatalf("expected error for missing server name")
+	}
+
+	// the current certificate is used while it's valid if re-issuing fails
+	f.Lock()
+	f.fail = true
+	f.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	current, err := config.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("error getting certificate: %v", err)
+	}
+	if current.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) < 0 {
+		t.Fatalf("expected current certificate")
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatalf("error closing client: %v", err)
+	}
+	f.Lock()
+	issued := f.issued
+	f.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	f.Lock()
+	defer f.Unlock()
+	if f.issued != issued {
+		t.Fatalf("certificate should not be re-issued after Close")
+	}
+}
+
+func TestPKITLSConfigErrors(t *testing.T) {
+	sc, err := NewSecretsClient(WithEnvVarBackend())
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if _, err := sc.PKITLSConfig("", "web", CertificateRequest{CommonName: "web.internal"}); err == nil {
+		t.Fatalf("expected error for non-Vault backend")
+	}
+	sc = newTestPKIClient(t, newFakePKIServer(t))
+	if _, err := sc.PKITLSConfig("pki_int", "../web", CertificateRequest{CommonName: "web.internal"}); err == nil {
+		t.Fatalf("expected error for invalid role")
+	}
+	if _, err := sc.PKITLSConfig("pki_int", "web", CertificateRequest{}); err == nil {
+		t.Fatalf("expected error for missing common name")
+	}
+	if _, err := sc.PKITLSConfig("pki", "web", CertificateRequest{CommonName: "web.internal"}); err == nil {
+		t.Fatalf("expected error for missing role")
+	}
+}
@@ -0,0 +1,79 @@
+package pvc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// VaultTransitCiphertextPrefix is the prefix of ciphertext produced by the Vault Transit engine (followed by the key
+// version, eg "vault:v1:...")
+const VaultTransitCiphertextPrefix = "vault:v"
+
+// DefaultVaultTransitMount is the default mount path of the Vault Transit engine
+const DefaultVaultTransitMount = "transit"
+
+// TransitDecrypt returns a Transformer that decrypts Vault Transit ciphertext with the named key through this client's
+// authenticated Vault connection. The client must use the Vault backend; the Transformer may be used with any client,
+// for example to decrypt values committed to a JSON file or set in environment variables:
+//
+//	dec, err := vaultClient.TransitDecrypt("", "myapp")
+//	sc, err := pvc.NewSecretsClient(pvc.WithJSONFileBackend("secrets.json"), pvc.WithTransform(dec))
+//
+// Values that don't begin with VaultTransitCiphertextPrefix (after trimming whitespace) are returned unchanged. The mount
+// path defaults to DefaultVaultTransitMount if empty.
+func (sc *SecretsClient) TransitDecrypt(mount, key string) (Transformer, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	vbg, ok := be.(*vaultBackendGetter)
+	if !ok {
+		return nil, fmt.Errorf("Transit decryption requires the Vault backend")
+	}
+	if _, err := validatePathID(key); err != nil || strings.Contains(key, "/") {
+		return nil, fmt.Errorf("invalid Transit key name: %q", key)
+	}
+	mount = authPath(mount, DefaultVaultTransitMount)
+	return func(value []byte) ([]byte, error) {
+		ct := bytes.TrimSpace(value)
+		if !bytes.HasPrefix(ct, []byte(VaultTransitCiphertextPrefix)) {
+			return value, nil
+		}
+		pt, err := vbg.vc.TransitDecrypt(mount, key, string(ct))
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting value with Transit key %v: %v", key, err)
+		}
+		return pt, nil
+	}, nil
+}
+
+// TransitDecrypt decrypts ciphertext with the named key of the Transit engine at mount
+func (c *vaultClient) TransitDecrypt(mount, key, ciphertext string) ([]byte, error) {
+	var s *api.Secret
+	err := c.do(func() (err error) {
+		client, _ := c.clientFor("")
+		s, err = client.Logical().Write(fmt.Sprintf("%v/decrypt/%v", mount, key), map[string]interface{}{
+			"ciphertext": ciphertext,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("empty decryption response")
+	}
+	b64, ok := s.Data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type for plaintext: %T", s.Data["plaintext"])
+	}
+	pt, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding plaintext: %v", err)
+	}
+	return pt, nil
+}
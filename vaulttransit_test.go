@@ -0,0 +1,89 @@
+package pvc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransitDecrypt(t *testing.T) {
+	vb := &vaultBackend{authentication: TokenVaultAuth, token: "dummy"}
+	c := newTestVaultClient(t, vb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"id": "dummy"}})
+		case "/v1/transit/decrypt/myapp":
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("error decoding request: %v", err)
+			}
+			if body["ciphertext"] != "vault:v1:Y2lwaGVydGV4dA==" {
+				w.WriteHeader(http.StatusBadRequest)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"invalid ciphertext"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{
+				"plaintext": base64.StdEncoding.EncodeToString([]byte("pa55w0rd")),
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	vbg, err := newVaultBackendGetter(vb, c)
+	if err != nil {
+		t.Fatalf("error creating backend: %v", err)
+	}
+	vsc := &SecretsClient{backend: vbg}
+	dec, err := vsc.TransitDecrypt("", "myapp")
+	if err != nil {
+		t.Fatalf("error getting transformer: %v", err)
+	}
+
+	secretsFile := filepath.Join(t.TempDir(), "secrets.json")
+	contents := `{"password": "vault:v1:Y2lwaGVydGV4dA==\n", "username": "app", "bad": "vault:v1:bad"}`
+	if err := os.WriteFile(secretsFile, []byte(contents), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	sc, err := NewSecretsClient(WithJSONFileBackend(secretsFile), WithTransform(dec))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "password", want: "pa55w0rd"},
+		{id: "username", want: "app"},
+		{id: "bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		v, err := sc.Get(tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%v: unexpected error: %v", tt.id, err)
+		}
+		if !tt.wantErr && string(v) != tt.want {
+			t.Fatalf("%v: bad value: %v (wanted %v)", tt.id, string(v), tt.want)
+		}
+	}
+}
+
+func TestTransitDecryptErrors(t *testing.T) {
+	sc, err := NewSecretsClient(WithEnvVarBackend())
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	if _, err := sc.TransitDecrypt("", "myapp"); err == nil || !strings.Contains(err.Error(), "Vault backend") {
+		t.Fatalf("expected Vault backend error, received: %v", err)
+	}
+	vsc := &SecretsClient{backend: &vaultBackendGetter{vc: &fakeVaultIO{}}}
+	for _, key := range []string{"", "../keys", "my/key"} {
+		if _, err := vsc.TransitDecrypt("", key); err == nil {
+			t.Fatalf("expected error for key name %q", key)
+		}
+	}
+}
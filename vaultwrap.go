@@ -0,0 +1,146 @@
+package pvc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// WrappedSecret is a single-use response-wrapping token for a secret, which can be handed to another process to unwrap
+type WrappedSecret struct {
+	Token        string
+	Accessor     string
+	TTL          time.Duration
+	CreationTime time.Time
+}
+
+// GetWrapped reads the secret for id as a response-wrapped secret valid for ttl, without reading the secret itself.
+// The wrapping token can be unwrapped once, by any process, with Unwrap or "vault unwrap". The client must use the
+// Vault backend.
+func (sc *SecretsClient) GetWrapped(id string, ttl time.Duration) (*WrappedSecret, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	vbg, ok := be.(*vaultBackendGetter)
+	if !ok {
+		return nil, fmt.Errorf("response wrapping requires the Vault backend")
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("wrap TTL must be at least one second: %v", ttl)
+	}
+	path, err := vbg.mapper.MapSecret(id)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping id to path: %v", err)
+	}
+	wi, err := vbg.vc.ReadWrapped(path, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("error reading wrapped secret (id: %v): %v", id, err)
+	}
+	return &WrappedSecret{
+		Token:        wi.Token,
+		Accessor:     wi.Accessor,
+		TTL:          time.Duration(wi.TTL) * time.Second,
+		CreationTime: wi.CreationTime,
+	}, nil
+}
+
+// Unwrap unwraps a response-wrapping token, such as one from GetWrapped, and returns the wrapped secret data. The client
+// must use the Vault backend, but doesn't need to be authorized to read the secret.
+func (sc *SecretsClient) Unwrap(token string) (map[string]interface{}, error) {
+	be, err := sc.getBackend()
+	if err != nil {
+		return nil, err
+	}
+	vbg, ok := be.(*vaultBackendGetter)
+	if !ok {
+		return nil, fmt.Errorf("response wrapping requires the Vault backend")
+	}
+	s, err := vbg.vc.Unwrap(token)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping: %v", err)
+	}
+	return s.Data, nil
+}
+
+// unwrapCredentials unwraps the wrapping token in the configuration to obtain the token or AppRole SecretID to log in with
+func unwrapCredentials(vb *vaultBackend, vc vaultIO) error {
+	s, err := vc.Unwrap(vb.wrappingtoken)
+	if err != nil {
+		return err
+	}
+	switch vb.authentication {
+	case TokenVaultAuth:
+		// a wrapped token creation response, or a wrapped secret containing a token
+		switch {
+		case s.Auth != nil && s.Auth.ClientToken != "":
+			vb.token = s.Auth.ClientToken
+		case s.Data["token"] != nil:
+			vb.token, _ = s.Data["token"].(string)
+		}
+		if vb.token == "" {
+			return fmt.Errorf("wrapped response does not contain a token")
+		}
+	case AppRoleVaultAuth:
+		vb.secretid, _ = s.Data["secret_id"].(string)
+		if vb.secretid == "" {
+			return fmt.Errorf("wrapped response does not contain a secret_id")
+		}
+	default:
+		return fmt.Errorf("wrapping tokens are only supported with token and AppRole authentication")
+	}
+	return nil
+}
+
+// Unwrap unwraps a response-wrapping token, authenticating with the wrapping token itself
+func (c *vaultClient) Unwrap(token string) (*api.Secret, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("wrapping token is empty")
+	}
+	// a separate client, so concurrent requests on the shared client keep using the client token
+	client, err := c.api().CloneWithHeaders()
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %v", err)
+	}
+	client.SetToken(token)
+	s, err := client.Logical().Unwrap(token)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("empty unwrap response")
+	}
+	return s, nil
+}
+
+// ReadWrapped reads the secret at path wrapped in a single-use token valid for ttl. The read isn't retried, since each
+// one creates a wrapping token.
+func (c *vaultClient) ReadWrapped(path string, ttl time.Duration) (*api.SecretWrapInfo, error) {
+	var s *api.Secret
+	location := path
+	err := c.doOnce(func() error {
+		var client *api.Client
+		client, path = c.clientFor(location)
+		req := client.NewRequest("GET", "/v1/"+path)
+		req.WrapTTL = fmt.Sprintf("%ds", int(ttl/time.Second))
+		resp, err := client.RawRequest(req)
+		if resp != nil {
+			defer resp.Body.Close()
+		}
+		if err != nil {
+			return err
+		}
+		s, err = api.ParseSecret(resp.Body)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret from Vault: %v: %v", path, err)
+	}
+	if s == nil || s.WrapInfo == nil {
+		return nil, fmt.Errorf("response was not wrapped: %v", path)
+	}
+	return s.WrapInfo, nil
+}
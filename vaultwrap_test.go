@@ -0,0 +1,164 @@
+package pvc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWrappingServer emulates Vault response wrapping with single-use wrapping tokens
+type fakeWrappingServer struct {
+	sync.Mutex
+	unwrapped map[string]bool
+}
+
+func (f *fakeWrappingServer) handler(t *testing.T) http.Handler {
+	wrapped := map[string]interface{}{
+		"s.wrappedtoken":    map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.realtoken"}},
+		"s.wrappedsecretid": map[string]interface{}{"data": map[string]interface{}{"secret_id": "secretid", "secret_id_accessor": "accessor"}},
+		"s.wrappedsecret":   map[string]interface{}{"data": map[string]interface{}{"value": "foo"}},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		token := r.Header.Get("X-Vault-Token")
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/unwrap":
+			resp, ok := wrapped[token]
+			if !ok || f.unwrapped[token] {
+				w.WriteHeader(http.StatusBadRequest)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"wrapping token is not valid or does not exist"}})
+				return
+			}
+			f.unwrapped[token] = true
+			writeVaultJSON(t, w, resp)
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("error decoding request: %v", err)
+			}
+			if body["role_id"] != "roleid" || body["secret_id"] != "secretid" {
+				w.WriteHeader(http.StatusBadRequest)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.realtoken"}})
+		case "/v1/auth/token/lookup-self":
+			if token != "s.realtoken" {
+				w.WriteHeader(http.StatusForbidden)
+				writeVaultJSON(t, w, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"data": map[string]interface{}{"id": token}})
+		case "/v1/secret/foo":
+			if token != "s.realtoken" || r.Header.Get("X-Vault-Wrap-TTL") != "60s" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			writeVaultJSON(t, w, map[string]interface{}{"wrap_info": map[string]interface{}{
+				"token":         "s.wrappedsecret",
+				"accessor":      "wrapaccessor",
+				"ttl":           60,
+				"creation_time": "2026-10-19T12:00:00Z",
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func newFakeWrappingServer(t *testing.T) *httptest.Server {
+	t.Setenv("VAULT_TOKEN", "")
+	srv := httptest.NewServer((&fakeWrappingServer{unwrapped: map[string]bool{}}).handler(t))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultWrappingTokenAuth(t *testing.T) {
+	srv := newFakeWrappingServer(t)
+	tests := []struct {
+		name string
+		ops  []SecretsClientOption
+	}{
+		{"token", []SecretsClientOption{
+			WithVaultBackend(TokenVaultAuth, srv.URL),
+			WithVaultWrappingToken("s.wrappedtoken"),
+		}},
+		{"approle", []SecretsClientOption{
+			WithVaultBackend(AppRoleVaultAuth, srv.URL),
+			WithVaultRoleID("roleid"),
+			WithVaultWrappingToken("s.wrappedsecretid\n"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := NewSecretsClient(tt.ops...)
+			if err != nil {
+				t.Fatalf("error getting SecretsClient: %v", err)
+			}
+			if c := sc.backend.(*vaultBackendGetter).vc.(*vaultClient); c.token != "s.realtoken" {
+				t.Fatalf("bad token: %v", c.token)
+			}
+			// wrapping tokens are single-use
+			if _, err := NewSecretsClient(tt.ops...); err == nil || !strings.Contains(err.Error(), "unwrapping") {
+				t.Fatalf("expected unwrapping error, received: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetWrapped(t *testing.T) {
+	srv := newFakeWrappingServer(t)
+	sc, err := NewSecretsClient(WithVaultBackend(TokenVaultAuth, srv.URL), WithVaultToken("s.realtoken"))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	ws, err := sc.GetWrapped("foo", time.Minute)
+	if err != nil {
+		t.Fatalf("error getting wrapped secret: %v", err)
+	}
+	want := WrappedSecret{Token: "s.wrappedsecret", Accessor: "wrapaccessor", TTL: time.Minute, CreationTime: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}
+	if *ws != want {
+		t.Fatalf("bad wrapped secret: %+v", ws)
+	}
+
+	// another process unwraps the secret
+	receiver, err := NewSecretsClient(WithVaultBackend(TokenVaultAuth, srv.URL), WithVaultToken("s.realtoken"))
+	if err != nil {
+		t.Fatalf("error getting SecretsClient: %v", err)
+	}
+	data, err := receiver.Unwrap(ws.Token)
+	if err != nil {
+		t.Fatalf("error unwrapping: %v", err)
+	}
+	if data["value"] != "foo" {
+		t.Fatalf("bad data: %v", data)
+	}
+	// the wrapping token is sent with a separate client
+	if tok := receiver.backend.(*vaultBackendGetter).vc.(*vaultClient).api().Token(); tok != "s.realtoken" {
+		t.Fatalf("shared client token changed: %v", tok)
+	}
+	if _, err := receiver.Unwrap(ws.Token); err == nil {
+		t.Fatalf("expected error unwrapping twice")
+	}
+	if _, err := sc.GetWrapped("foo", time.Millisecond); err == nil {
+		t.Fatalf("expected error for short TTL")
+	}
+}
+
+func TestVaultWrappingTokenErrors(t *testing.T) {
+	getVaultClient = newFakeVaultClient
+	defer func() { getVaultClient = newVaultClient }()
+	_, err := NewSecretsClient(WithVaultBackend(TokenVaultAuth, "foo"), WithVaultWrappingToken("s.wrapped"))
+	if err == nil || !strings.Contains(err.Error(), "does not contain a token") {
+		t.Fatalf("expected missing token error, received: %v", err)
+	}
+	_, err = NewSecretsClient(WithVaultBackend(K8sVaultAuth, "foo"), WithVaultWrappingToken("s.wrapped"))
+	if err == nil || !strings.Contains(err.Error(), "only supported") {
+		t.Fatalf("expected unsupported authentication error, received: %v", err)
+	}
+}